@@ -13,7 +13,7 @@ import (
 type memorySession struct {
 	*session.MemorySession
 
-	subscriptions *topic.Tree
+	subscriptions *topic.TypedTree[packet.Subscription]
 	stored        chan *packet.Message
 	temporary     chan *packet.Message
 
@@ -23,7 +23,7 @@ type memorySession struct {
 func newMemorySession(backlog int) *memorySession {
 	return &memorySession{
 		MemorySession: session.NewMemorySession(),
-		subscriptions: topic.NewTree(),
+		subscriptions: topic.NewTypedTree[packet.Subscription](nil),
 		stored:        make(chan *packet.Message, backlog),
 		temporary:     make(chan *packet.Message, backlog),
 	}
@@ -33,8 +33,7 @@ func (s *memorySession) lookupSubscription(topic string) *packet.Subscription {
 	values := s.subscriptions.Match(topic)
 
 	if len(values) > 0 {
-		sub := values[0].(packet.Subscription)
-		return &sub
+		return &values[0]
 	}
 
 	return nil
@@ -87,17 +86,28 @@ type MemoryBackend struct {
 	ClientParallelSubscribes int
 	ClientInflightMessages   int
 	ClientTokenTimeout       time.Duration
+	ClientWillDelay          time.Duration
 
 	// A map of username and passwords that grant read and write access.
 	Credentials map[string]string
 
+	// The store used to save retained messages.
+	//
+	// Will default to a MemoryRetainedStore without limits.
+	RetainedStore RetainedStore
+
+	// The duration after which retained messages expire.
+	//
+	// Will default to no expiry if zero.
+	RetainedExpiry time.Duration
+
 	// The Logger callback handles incoming log events.
 	Logger func(LogEvent, *Client, packet.Generic, *packet.Message, error)
 
 	activeClients     map[string]*Client
 	storedSessions    map[string]*memorySession
 	temporarySessions map[*Client]*memorySession
-	retainedMessages  *topic.Tree
+	subscribers       *topic.Matcher[*memorySession]
 
 	globalMutex sync.Mutex
 	setupMutex  sync.Mutex
@@ -112,7 +122,8 @@ func NewMemoryBackend() *MemoryBackend {
 		activeClients:     make(map[string]*Client),
 		storedSessions:    make(map[string]*memorySession),
 		temporarySessions: make(map[*Client]*memorySession),
-		retainedMessages:  topic.NewTree(),
+		subscribers:       topic.NewMatcher[*memorySession](),
+		RetainedStore:     NewMemoryRetainedStore(),
 	}
 }
 
@@ -160,6 +171,7 @@ func (m *MemoryBackend) Setup(client *Client, id string, clean bool) (Session, b
 	client.ParallelSubscribes = m.ClientParallelSubscribes
 	client.InflightMessages = m.ClientInflightMessages
 	client.TokenTimeout = m.ClientTokenTimeout
+	client.WillDelay = m.ClientWillDelay
 
 	// return a new temporary session if id is zero
 	if len(id) == 0 {
@@ -185,7 +197,8 @@ func (m *MemoryBackend) Setup(client *Client, id string, clean bool) (Session, b
 
 	// kill existing client if session is taken
 	if ok && existingSession.owner != nil {
-		// close client
+		// cancel delayed will and close client
+		existingSession.owner.CancelWill()
 		existingSession.owner.Close()
 
 		// release global mutex to allow publish and termination, but leave the
@@ -214,7 +227,10 @@ func (m *MemoryBackend) Setup(client *Client, id string, clean bool) (Session, b
 	// session is requested
 	if clean {
 		// delete any stored session
-		delete(m.storedSessions, id)
+		if storedSession, ok := m.storedSessions[id]; ok {
+			m.forget(storedSession)
+			delete(m.storedSessions, id)
+		}
 
 		// create new session
 		sess := newMemorySession(m.SessionQueueSize)
@@ -266,9 +282,13 @@ func (m *MemoryBackend) Subscribe(client *Client, subs []packet.Subscription, ac
 	m.globalMutex.Lock()
 	defer m.globalMutex.Unlock()
 
+	// get session
+	sess := client.Session().(*memorySession)
+
 	// save subscription
 	for _, sub := range subs {
-		client.Session().(*memorySession).subscriptions.Set(sub.Topic, sub)
+		sess.subscriptions.Set(sub.Topic, sub)
+		m.subscribers.Add(sub.Topic, sess)
 	}
 
 	// call ack if provided
@@ -276,19 +296,19 @@ func (m *MemoryBackend) Subscribe(client *Client, subs []packet.Subscription, ac
 		ack()
 	}
 
-	// get session
-	sess := client.Session().(*memorySession)
-
 	// handle all subscriptions
 	for _, sub := range subs {
 		// get retained messages
-		values := m.retainedMessages.Search(sub.Topic)
+		msgs, err := m.RetainedStore.Search(sub.Topic)
+		if err != nil {
+			return err
+		}
 
 		// publish messages
-		for _, value := range values {
+		for _, msg := range msgs {
 			// add to temporary queue or return error if queue is full
 			select {
-			case sess.temporary <- value.(*packet.Message):
+			case sess.temporary <- msg:
 			default:
 				return ErrQueueFull
 			}
@@ -300,9 +320,13 @@ func (m *MemoryBackend) Subscribe(client *Client, subs []packet.Subscription, ac
 
 // Unsubscribe will delete the subscription.
 func (m *MemoryBackend) Unsubscribe(client *Client, topics []string, ack Ack) error {
+	// get session
+	sess := client.Session().(*memorySession)
+
 	// delete subscriptions
 	for _, t := range topics {
-		client.Session().(*memorySession).subscriptions.Empty(t)
+		sess.subscriptions.Empty(t)
+		m.subscribers.Remove(t, sess)
 	}
 
 	// call ack if provided
@@ -326,11 +350,19 @@ func (m *MemoryBackend) Publish(client *Client, msg *packet.Message, ack Ack) er
 	// check retain flag
 	if msg.Retain {
 		if len(msg.Payload) > 0 {
-			// retain message
-			m.retainedMessages.Set(msg.Topic, msg.Copy())
+			// retain message, a rejected message is still published
+			err := m.RetainedStore.Store(msg, m.RetainedExpiry)
+			if errors.Is(err, ErrRetainedLimitReached) || errors.Is(err, ErrRetainedPayloadTooLarge) {
+				m.Log(MessageNotRetained, client, nil, msg, err)
+			} else if err != nil {
+				return err
+			}
 		} else {
 			// clear already retained message
-			m.retainedMessages.Empty(msg.Topic)
+			err := m.RetainedStore.Remove(msg.Topic)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
@@ -349,50 +381,27 @@ func (m *MemoryBackend) Publish(client *Client, msg *packet.Message, ack Ack) er
 	// reset retained flag
 	msg.Retain = false
 
-	// add message to temporary sessions
-	for _, sess := range m.temporarySessions {
-		if sub := sess.lookupSubscription(msg.Topic); sub != nil {
-			if sess.owner == client {
-				// detect deadlock when adding to own queue
-				select {
-				case queue(sess) <- msg:
-				default:
-					return ErrQueueFull
-				}
-			} else {
-				// wait for room since client is online
-				select {
-				case queue(sess) <- msg:
-				case <-sess.owner.Closed():
-				case <-client.Closed():
-				}
+	// add message to subscribed sessions
+	for _, sess := range m.subscribers.Match(msg.Topic) {
+		if sess.owner == client {
+			// detect deadlock when adding to own queue
+			select {
+			case queue(sess) <- msg:
+			default:
+				return ErrQueueFull
 			}
-		}
-	}
-
-	// add message to stored sessions
-	for _, sess := range m.storedSessions {
-		if sub := sess.lookupSubscription(msg.Topic); sub != nil {
-			if sess.owner == client {
-				// detect deadlock when adding to own queue
-				select {
-				case queue(sess) <- msg:
-				default:
-					return ErrQueueFull
-				}
-			} else if sess.owner != nil {
-				// wait for room if client is online
-				select {
-				case queue(sess) <- msg:
-				case <-sess.owner.Closed():
-				case <-client.Closed():
-				}
-			} else {
-				// ignore message if stored queue is full
-				select {
-				case queue(sess) <- msg:
-				default:
-				}
+		} else if sess.owner != nil {
+			// wait for room if client is online
+			select {
+			case queue(sess) <- msg:
+			case <-sess.owner.Closing():
+			case <-client.Closed():
+			}
+		} else {
+			// ignore message if stored queue is full
+			select {
+			case queue(sess) <- msg:
+			default:
 			}
 		}
 	}
@@ -438,8 +447,11 @@ func (m *MemoryBackend) Terminate(client *Client) error {
 		sess.owner = nil
 	}
 
-	// remove any temporary session
-	delete(m.temporarySessions, client)
+	// remove any temporary session and its subscriptions
+	if tempSession, ok := m.temporarySessions[client]; ok {
+		m.forget(tempSession)
+		delete(m.temporarySessions, client)
+	}
 
 	// remove any saved client
 	delete(m.activeClients, client.ID())
@@ -447,6 +459,14 @@ func (m *MemoryBackend) Terminate(client *Client) error {
 	return nil
 }
 
+// forget will remove the subscriptions of the session from the subscribers
+func (m *MemoryBackend) forget(sess *memorySession) {
+	sess.subscriptions.Walk(func(topic string, _ []packet.Subscription) bool {
+		m.subscribers.Remove(topic, sess)
+		return true
+	})
+}
+
 // Log will call the associated logger.
 func (m *MemoryBackend) Log(event LogEvent, client *Client, pkt packet.Generic, msg *packet.Message, err error) {
 	// call logger if available
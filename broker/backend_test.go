@@ -86,3 +86,59 @@ func TestMemoryBackendClose(t *testing.T) {
 
 	safeReceive(done)
 }
+
+func TestMemoryBackendSubscribers(t *testing.T) {
+	backend := NewMemoryBackend()
+
+	port, quit, done := Run(NewEngine(backend), "tcp")
+
+	options := client.NewConfigWithClientID("tcp://localhost:"+port, "subscribers")
+	options.CleanSession = false
+
+	client1 := client.New()
+
+	cf, err := client1.Connect(options)
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+
+	sf, err := client1.SubscribeMultiple([]packet.Subscription{
+		{Topic: "foo", QOS: 0},
+		{Topic: "bar/#", QOS: 0},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, sf.Wait(10*time.Second))
+	assert.Equal(t, 2, backend.subscribers.Count())
+
+	uf, err := client1.Unsubscribe("foo")
+	assert.NoError(t, err)
+	assert.NoError(t, uf.Wait(10*time.Second))
+	assert.Equal(t, 1, backend.subscribers.Count())
+
+	assert.NoError(t, client1.Disconnect())
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, backend.subscribers.Count())
+
+	options.CleanSession = true
+
+	client2 := client.New()
+
+	cf, err = client2.Connect(options)
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+	assert.Equal(t, 0, backend.subscribers.Count())
+
+	sf, err = client2.Subscribe("baz", 0)
+	assert.NoError(t, err)
+	assert.NoError(t, sf.Wait(10*time.Second))
+	assert.Equal(t, 1, backend.subscribers.Count())
+
+	assert.NoError(t, client2.Disconnect())
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, backend.subscribers.Count())
+
+	close(quit)
+
+	safeReceive(done)
+}
@@ -2,11 +2,13 @@ package broker
 
 import (
 	"errors"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/256dpi/gomqtt/packet"
 	"github.com/256dpi/gomqtt/session"
+	"github.com/256dpi/gomqtt/topic"
 	"github.com/256dpi/gomqtt/transport"
 
 	"gopkg.in/tomb.v2"
@@ -25,6 +27,10 @@ const (
 	// MessagePublished is emitted after a message has been published.
 	MessagePublished LogEvent = "message published"
 
+	// MessageNotRetained is emitted when the retained store rejected a
+	// message. The message is still published without being retained.
+	MessageNotRetained LogEvent = "message not retained"
+
 	// MessageAcknowledged is emitted after a message has been acknowledged.
 	MessageAcknowledged LogEvent = "message acknowledged"
 
@@ -58,8 +64,10 @@ const (
 
 // A Session is used to get packet ids and persist incoming/outgoing packets.
 type Session interface {
-	// NextID should return the next id for outgoing packets.
-	NextID() packet.ID
+	// NextID should return the next id for outgoing packets. Ids that are still
+	// in use by stored outgoing packets should be skipped and an error should
+	// be returned if no id is available.
+	NextID() (packet.ID, error)
 
 	// SavePacket should store a packet in the session. An eventual existing
 	// packet with the same id should be quietly overwritten.
@@ -93,7 +101,8 @@ type Backend interface {
 	// supplied id or create and return a new one if it is missing or a clean
 	// session is requested. If the supplied id has a zero length, a new
 	// temporary session should be returned that is not stored further. The
-	// backend should also close any existing clients that use the same id.
+	// backend should also close any existing clients that use the same id and
+	// cancel their delayed wills using CancelWill.
 	//
 	// Note: In this call the Backend may also allocate other resources and
 	// setup the client for further usage as the broker will acknowledge the
@@ -173,6 +182,10 @@ var ErrUnexpectedPacket = errors.New("unexpected packet")
 // ErrNotAuthorized is returned when a client is not authorized.
 var ErrNotAuthorized = errors.New("not authorized")
 
+// ErrKeepAliveExceeded is returned when a client requests a keep alive that
+// exceeds the maximum keep alive.
+var ErrKeepAliveExceeded = errors.New("keep alive exceeded")
+
 // ErrMissingSession is returned if the backend does not return a session.
 var ErrMissingSession = errors.New("missing session")
 
@@ -225,13 +238,33 @@ type Client struct {
 	// Disconnect packets are not provided to the callback.
 	PacketCallback func(packet.Generic) error
 
+	// WillDelay may be set during Setup to delay the publishing of the will
+	// message after the connection has been lost. A will that is delayed is
+	// not published if CancelWill is called before the delay has passed. If
+	// the client is closed during the delay, the will is published immediately.
+	WillDelay time.Duration
+
+	// WillCallback may be set during Setup to inspect the will message before
+	// it is published. The callback may return the same or a rewritten message
+	// or nil to suppress the will. If an error is returned, the will is
+	// suppressed and the error is logged.
+	WillCallback func(*packet.Message) (*packet.Message, error)
+
 	state   uint32
 	backend Backend
 	conn    transport.Conn
 
-	id      string
-	will    *packet.Message
-	session Session
+	id        string
+	will      *packet.Message
+	session   Session
+	keepAlive time.Duration
+
+	minKeepAlive time.Duration
+	maxKeepAlive time.Duration
+	idleTimeout  time.Duration
+	idGenerator  func() string
+	topicParser  *topic.Parser
+	compression  *packet.Compression
 
 	ackQueue chan packet.Generic
 
@@ -239,6 +272,11 @@ type Client struct {
 	subscribeTokens chan struct{}
 	dequeueTokens   chan struct{}
 
+	willCancel chan struct{}
+	willOnce   sync.Once
+	kill       chan struct{}
+	killOnce   sync.Once
+
 	tomb tomb.Tomb
 	done chan struct{}
 }
@@ -246,13 +284,26 @@ type Client struct {
 // NewClient takes over a connection and returns a Client.
 func NewClient(backend Backend, conn transport.Conn) *Client {
 	// create client
-	c := &Client{
-		state:   clientConnecting,
-		backend: backend,
-		conn:    conn,
-		done:    make(chan struct{}),
+	c := newClient(backend, conn)
+
+	// start client
+	c.start()
+
+	return c
+}
+
+func newClient(backend Backend, conn transport.Conn) *Client {
+	return &Client{
+		state:      clientConnecting,
+		backend:    backend,
+		conn:       conn,
+		willCancel: make(chan struct{}),
+		kill:       make(chan struct{}),
+		done:       make(chan struct{}),
 	}
+}
 
+func (c *Client) start() {
 	// start processor
 	c.tomb.Go(c.processor)
 
@@ -265,8 +316,6 @@ func NewClient(backend Backend, conn transport.Conn) *Client {
 		// close channel
 		close(c.done)
 	}()
-
-	return c
 }
 
 // Session returns the current Session used by the client.
@@ -274,21 +323,44 @@ func (c *Client) Session() Session {
 	return c.session
 }
 
-// ID returns the clients id that has been supplied during connect.
+// ID returns the clients id that has been supplied during connect or that
+// has been assigned by the engine.
 func (c *Client) ID() string {
 	return c.id
 }
 
+// KeepAlive returns the keep alive that is enforced for the client. It is
+// derived from the value requested during connect and the limits configured on
+// the engine. A zero value denotes that keep alive is disabled.
+func (c *Client) KeepAlive() time.Duration {
+	return c.keepAlive
+}
+
 // Conn returns the client's underlying connection. Calls to SetReadLimit,
 // LocalAddr and RemoteAddr are safe.
 func (c *Client) Conn() transport.Conn {
 	return c.conn
 }
 
-// Close will immediately close the client.
+// Close will immediately close the client. A will that is currently delayed
+// will be published immediately.
 func (c *Client) Close() {
 	c.tomb.Kill(ErrClientClosed)
 	c.conn.Close()
+
+	// end will delay
+	c.killOnce.Do(func() {
+		close(c.kill)
+	})
+}
+
+// CancelWill will prevent a delayed will from being published. The backend
+// should call it in Setup before closing an existing client that uses the same
+// id. Wills that are not delayed are not affected.
+func (c *Client) CancelWill() {
+	c.willOnce.Do(func() {
+		close(c.willCancel)
+	})
 }
 
 // Closing returns a channel that is closed when the client is closing.
@@ -345,6 +417,16 @@ func (c *Client) processor() error {
 
 		c.backend.Log(PacketReceived, c, pkt, nil, nil)
 
+		// decompress payload
+		if publish, ok := pkt.(*packet.Publish); ok && c.compression != nil {
+			msg, err := c.compression.Decompress(&publish.Message)
+			if err != nil {
+				return c.die(ClientError, err)
+			}
+
+			publish.Message = *msg
+		}
+
 		// call callback
 		if c.PacketCallback != nil && pkt.Type() != packet.DISCONNECT {
 			err = c.PacketCallback(pkt)
@@ -384,13 +466,24 @@ func (c *Client) dequeuer() error {
 
 		c.backend.Log(MessageDequeued, c, nil, msg, nil)
 
+		// compress payload
+		if c.compression != nil {
+			msg, err = c.compression.Compress(msg)
+			if err != nil {
+				return c.die(ClientError, err)
+			}
+		}
+
 		// prepare publish packet
 		publish := packet.NewPublish()
 		publish.Message = *msg
 
 		// set packet id
 		if publish.Message.QOS > 0 {
-			publish.ID = c.session.NextID()
+			publish.ID, err = c.session.NextID()
+			if err != nil {
+				return c.die(SessionError, err)
+			}
 		}
 
 		// store packet if at least qos 1
@@ -474,6 +567,45 @@ func (c *Client) processConnect(pkt *packet.Connect) error {
 	// save id
 	c.id = pkt.ClientID
 
+	// get clean session
+	clean := pkt.CleanSession
+
+	// assign id if missing
+	if c.id == "" && c.idGenerator != nil {
+		c.id = c.idGenerator()
+
+		// the session cannot be resumed using an assigned id
+		clean = true
+	}
+
+	// check will topic
+	if pkt.Will != nil {
+		topic, err := c.parseTopic(pkt.Will.Topic, false)
+		if err != nil {
+			return c.die(ClientError, err)
+		}
+
+		pkt.Will.Topic = topic
+	}
+
+	// get requested keep alive
+	keepAlive := time.Duration(pkt.KeepAlive) * time.Second
+
+	// reject keep alive exceeding the maximum, the protocol has no dedicated
+	// return code and the client will assume that it is not authorized
+	if keepAlive > 0 && c.maxKeepAlive > 0 && keepAlive > c.maxKeepAlive {
+		// send connack
+		connack := packet.NewConnack()
+		connack.ReturnCode = packet.NotAuthorized
+		err := c.send(connack, false)
+		if err != nil {
+			return c.die(TransportError, err)
+		}
+
+		// close client
+		return c.die(ClientError, ErrKeepAliveExceeded)
+	}
+
 	// authenticate
 	ok, err := c.backend.Authenticate(c, pkt.Username, pkt.Password)
 	if err != nil {
@@ -503,15 +635,23 @@ func (c *Client) processConnect(pkt *packet.Connect) error {
 	// set state
 	atomic.StoreUint32(&c.state, clientConnected)
 
-	// set keep alive
-	if pkt.KeepAlive > 0 {
-		c.conn.SetReadTimeout(time.Duration(pkt.KeepAlive) * 1500 * time.Millisecond)
+	// enforce minimum keep alive
+	if keepAlive > 0 && c.minKeepAlive > 0 && keepAlive < c.minKeepAlive {
+		keepAlive = c.minKeepAlive
+	}
+
+	// save keep alive
+	c.keepAlive = keepAlive
+
+	// set read timeout
+	if keepAlive > 0 {
+		c.conn.SetReadTimeout(keepAlive * 3 / 2)
 	} else {
-		c.conn.SetReadTimeout(0)
+		c.conn.SetReadTimeout(c.idleTimeout)
 	}
 
 	// retrieve session
-	s, resumed, err := c.backend.Setup(c, pkt.ClientID, pkt.CleanSession)
+	s, resumed, err := c.backend.Setup(c, c.id, clean)
 	if err != nil {
 		return c.die(BackendError, err)
 	} else if s == nil {
@@ -519,7 +659,7 @@ func (c *Client) processConnect(pkt *packet.Connect) error {
 	}
 
 	// set session present
-	connack.SessionPresent = !pkt.CleanSession && resumed
+	connack.SessionPresent = !clean && resumed
 
 	// assign session
 	c.session = s
@@ -663,6 +803,16 @@ func (c *Client) processPingreq() error {
 
 // handle an incoming subscribe packet
 func (c *Client) processSubscribe(pkt *packet.Subscribe) error {
+	// check topics
+	for i, subscription := range pkt.Subscriptions {
+		topic, err := c.parseTopic(subscription.Topic, true)
+		if err != nil {
+			return c.die(ClientError, err)
+		}
+
+		pkt.Subscriptions[i].Topic = topic
+	}
+
 	// acquire subscribe token
 	select {
 	case <-c.subscribeTokens:
@@ -699,6 +849,16 @@ func (c *Client) processSubscribe(pkt *packet.Subscribe) error {
 
 // handle an incoming unsubscribe packet
 func (c *Client) processUnsubscribe(pkt *packet.Unsubscribe) error {
+	// check topics
+	for i, t := range pkt.Topics {
+		topic, err := c.parseTopic(t, true)
+		if err != nil {
+			return c.die(ClientError, err)
+		}
+
+		pkt.Topics[i] = topic
+	}
+
 	// acquire subscribe token
 	select {
 	case <-c.subscribeTokens:
@@ -729,6 +889,15 @@ func (c *Client) processUnsubscribe(pkt *packet.Unsubscribe) error {
 
 // handle an incoming publish packet
 func (c *Client) processPublish(publish *packet.Publish) error {
+	// check topic
+	topic, err := c.parseTopic(publish.Message.Topic, false)
+	if err != nil {
+		return c.die(ClientError, err)
+	}
+
+	// set topic
+	publish.Message.Topic = topic
+
 	// handle qos 0 flow
 	if publish.Message.QOS == 0 {
 		// publish message
@@ -796,6 +965,16 @@ func (c *Client) processPublish(publish *packet.Publish) error {
 	return nil
 }
 
+// parse a received topic if a parser is available
+func (c *Client) parseTopic(topic string, allowWildcards bool) (string, error) {
+	// return topic as is if no parser is set
+	if c.topicParser == nil {
+		return topic, nil
+	}
+
+	return c.topicParser.Parse(topic, allowWildcards)
+}
+
 // handle an incoming p or pubcomp packet
 func (c *Client) processPubackAndPubcomp(id packet.ID) error {
 	// remove packet from store
@@ -925,13 +1104,7 @@ func (c *Client) die(event LogEvent, err error) error {
 func (c *Client) cleanup() {
 	// check if not cleanly connected and will is present
 	if atomic.LoadUint32(&c.state) == clientConnected && c.will != nil {
-		// publish message
-		err := c.backend.Publish(c, c.will, nil)
-		if err != nil {
-			c.backend.Log(BackendError, c, nil, nil, err)
-		}
-
-		c.backend.Log(MessagePublished, c, nil, c.will, nil)
+		c.publishWill()
 	}
 
 	// remove client from the queue
@@ -944,3 +1117,46 @@ func (c *Client) cleanup() {
 
 	c.backend.Log(LostConnection, c, nil, nil, nil)
 }
+
+// will publish the will after the delay unless canceled
+func (c *Client) publishWill() {
+	// wait for delay
+	if c.WillDelay > 0 {
+		select {
+		case <-time.After(c.WillDelay):
+		case <-c.kill:
+		case <-c.willCancel:
+		}
+
+		// check if canceled
+		select {
+		case <-c.willCancel:
+			return
+		default:
+		}
+	}
+
+	// get will
+	will := c.will
+
+	// call callback
+	if c.WillCallback != nil {
+		var err error
+		will, err = c.WillCallback(will)
+		if err != nil {
+			c.backend.Log(BackendError, c, nil, nil, err)
+			return
+		} else if will == nil {
+			return
+		}
+	}
+
+	// publish message
+	err := c.backend.Publish(c, will, nil)
+	if err != nil {
+		c.backend.Log(BackendError, c, nil, nil, err)
+		return
+	}
+
+	c.backend.Log(MessagePublished, c, nil, will, nil)
+}
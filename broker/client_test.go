@@ -148,3 +148,177 @@ func TestClientTokenTimeoutDequeue(t *testing.T) {
 
 	safeReceive(done)
 }
+
+func TestClientWillDelay(t *testing.T) {
+	backend := NewMemoryBackend()
+	backend.ClientWillDelay = 50 * time.Millisecond
+
+	port, quit, done := Run(NewEngine(backend), "tcp")
+
+	wait := make(chan struct{})
+	client1 := client.New()
+	client1.Callback = func(msg *packet.Message, err error) error {
+		assert.NoError(t, err)
+		assert.Equal(t, "wd/1", msg.Topic)
+		close(wait)
+		return nil
+	}
+
+	cf, err := client1.Connect(client.NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+
+	sf, err := client1.Subscribe("wd/1", 0)
+	assert.NoError(t, err)
+	assert.NoError(t, sf.Wait(10*time.Second))
+
+	conn, err := transport.Dial("tcp://localhost:" + port)
+	assert.NoError(t, err)
+
+	connect := packet.NewConnect()
+	connect.ClientID = "wd1"
+	connect.Will = &packet.Message{Topic: "wd/1", Payload: []byte("gone")}
+
+	start := time.Now()
+
+	err = flow.New().
+		Send(connect).
+		Receive(packet.NewConnack()).
+		Close().
+		Test(conn)
+	assert.NoError(t, err)
+
+	safeReceive(wait)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	err = client1.Disconnect()
+	assert.NoError(t, err)
+
+	close(quit)
+
+	safeReceive(done)
+}
+
+func TestClientWillDelayCancel(t *testing.T) {
+	backend := NewMemoryBackend()
+	backend.ClientWillDelay = 100 * time.Millisecond
+
+	port, quit, done := Run(NewEngine(backend), "tcp")
+
+	client1 := client.New()
+	client1.Callback = func(msg *packet.Message, err error) error {
+		assert.Fail(t, "should not be called")
+		return nil
+	}
+
+	cf, err := client1.Connect(client.NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+
+	sf, err := client1.Subscribe("wd/2", 0)
+	assert.NoError(t, err)
+	assert.NoError(t, sf.Wait(10*time.Second))
+
+	connect := packet.NewConnect()
+	connect.ClientID = "wd2"
+	connect.Will = &packet.Message{Topic: "wd/2", Payload: []byte("gone")}
+
+	conn1, err := transport.Dial("tcp://localhost:" + port)
+	assert.NoError(t, err)
+
+	err = flow.New().
+		Send(connect).
+		Receive(packet.NewConnack()).
+		Close().
+		Test(conn1)
+	assert.NoError(t, err)
+
+	conn2, err := transport.Dial("tcp://localhost:" + port)
+	assert.NoError(t, err)
+
+	err = flow.New().
+		Send(connect).
+		Receive(packet.NewConnack()).
+		Send(packet.NewDisconnect()).
+		End().
+		Test(conn2)
+	assert.NoError(t, err)
+
+	time.Sleep(200 * time.Millisecond)
+
+	err = client1.Disconnect()
+	assert.NoError(t, err)
+
+	close(quit)
+
+	safeReceive(done)
+}
+
+type willMemoryBackend struct {
+	MemoryBackend
+}
+
+func (b *willMemoryBackend) Setup(client *Client, id string, clean bool) (Session, bool, error) {
+	client.WillCallback = func(msg *packet.Message) (*packet.Message, error) {
+		if msg.Topic == "wc/deny" {
+			return nil, nil
+		}
+
+		msg = msg.Copy()
+		msg.Payload = []byte("rewritten")
+
+		return msg, nil
+	}
+
+	return b.MemoryBackend.Setup(client, id, clean)
+}
+
+func TestClientWillCallback(t *testing.T) {
+	backend := &willMemoryBackend{
+		MemoryBackend: *NewMemoryBackend(),
+	}
+
+	port, quit, done := Run(NewEngine(backend), "tcp")
+
+	wait := make(chan struct{})
+	client1 := client.New()
+	client1.Callback = func(msg *packet.Message, err error) error {
+		assert.NoError(t, err)
+		assert.Equal(t, "wc/allow", msg.Topic)
+		assert.Equal(t, []byte("rewritten"), msg.Payload)
+		close(wait)
+		return nil
+	}
+
+	cf, err := client1.Connect(client.NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+
+	sf, err := client1.Subscribe("wc/#", 0)
+	assert.NoError(t, err)
+	assert.NoError(t, sf.Wait(10*time.Second))
+
+	for _, topic := range []string{"wc/deny", "wc/allow"} {
+		conn, err := transport.Dial("tcp://localhost:" + port)
+		assert.NoError(t, err)
+
+		connect := packet.NewConnect()
+		connect.Will = &packet.Message{Topic: topic, Payload: []byte("gone")}
+
+		err = flow.New().
+			Send(connect).
+			Receive(packet.NewConnack()).
+			Close().
+			Test(conn)
+		assert.NoError(t, err)
+	}
+
+	safeReceive(wait)
+
+	err = client1.Disconnect()
+	assert.NoError(t, err)
+
+	close(quit)
+
+	safeReceive(done)
+}
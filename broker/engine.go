@@ -2,10 +2,14 @@
 package broker
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"net"
 	"sync"
 	"time"
 
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/topic"
 	"github.com/256dpi/gomqtt/transport"
 
 	"gopkg.in/tomb.v2"
@@ -22,6 +26,43 @@ type Engine struct {
 	// The DefaultReadLimit defines the initial read limit.
 	DefaultReadLimit int64
 
+	// MinKeepAlive defines the minimum keep alive that is enforced. Clients
+	// requesting a shorter keep alive are timed out using the minimum.
+	MinKeepAlive time.Duration
+
+	// MaxKeepAlive defines the maximum keep alive that is allowed. Clients
+	// requesting a longer keep alive are rejected as the protocol provides no
+	// way to inform them about a shorter keep alive.
+	//
+	// Note: Rejected clients receive a connack with the NotAuthorized return
+	// code, as the protocol has no return code for invalid keep alives. Clients
+	// will therefore assume that their credentials have been refused. A
+	// client.Service that is configured to stop or escalate on NotAuthorized
+	// will halt because of its keep alive setting.
+	MaxKeepAlive time.Duration
+
+	// IdleTimeout defines the read timeout that is applied to clients that
+	// disable keep alive by requesting a keep alive of zero. Idle connections
+	// are never closed if zero.
+	IdleTimeout time.Duration
+
+	// ClientIDGenerator can be set to assign generated ids to clients that
+	// connect with an empty client id. Sessions of clients with an assigned id
+	// are always clean. See NewClientIDGenerator for a default implementation.
+	ClientIDGenerator func() string
+
+	// TopicParser can be set to validate the topics of received will messages,
+	// publish, subscribe and unsubscribe packets. The topics are replaced with
+	// the parsed topics, which allows selecting between strict validation and
+	// normalization. Clients that send invalid topics are disconnected.
+	TopicParser *topic.Parser
+
+	// Compression can be set to decompress the payloads of received messages
+	// before they are passed to the PacketCallback and the backend and to
+	// compress them again before they are sent to clients. If no limit is set,
+	// the DefaultReadLimit is used to limit decompressed payloads.
+	Compression *packet.Compression
+
 	// OnError can be used to receive errors from engine. If an error is received
 	// the server should be restarted.
 	OnError func(error)
@@ -90,8 +131,24 @@ func (e *Engine) Handle(conn transport.Conn) bool {
 	// set initial read timeout
 	conn.SetReadTimeout(e.ConnectTimeout)
 
+	// prepare client
+	client := newClient(e.Backend, conn)
+	client.minKeepAlive = e.MinKeepAlive
+	client.maxKeepAlive = e.MaxKeepAlive
+	client.idleTimeout = e.IdleTimeout
+	client.idGenerator = e.ClientIDGenerator
+	client.topicParser = e.TopicParser
+	client.compression = e.Compression
+
+	// use read limit as decompression limit
+	if e.Compression != nil && e.Compression.Limit <= 0 && e.DefaultReadLimit > 0 {
+		compression := *e.Compression
+		compression.Limit = e.DefaultReadLimit
+		client.compression = &compression
+	}
+
 	// handle client
-	NewClient(e.Backend, conn)
+	client.start()
 
 	return true
 }
@@ -110,6 +167,21 @@ func (e *Engine) Close() {
 	e.tomb.Wait()
 }
 
+// NewClientIDGenerator returns a function that generates unique client ids
+// using the specified prefix and a random suffix.
+func NewClientIDGenerator(prefix string) func() string {
+	return func() string {
+		// read random bytes
+		buf := make([]byte, 12)
+		_, err := rand.Read(buf)
+		if err != nil {
+			panic(err)
+		}
+
+		return prefix + hex.EncodeToString(buf)
+	}
+}
+
 // Run runs the passed engine on a random available port and returns a channel
 // that can be closed to shutdown the engine. This method is intended to be used
 // in testing scenarios.
@@ -1,12 +1,15 @@
 package broker
 
 import (
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/256dpi/gomqtt/client"
 	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/topic"
 	"github.com/256dpi/gomqtt/transport"
+	"github.com/256dpi/gomqtt/transport/flow"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -51,3 +54,337 @@ func TestDefaultReadLimit(t *testing.T) {
 	close(quit)
 	safeReceive(done)
 }
+
+func TestIdleTimeout(t *testing.T) {
+	engine := NewEngine(NewMemoryBackend())
+	engine.IdleTimeout = 50 * time.Millisecond
+
+	port, quit, done := Run(engine, "tcp")
+
+	conn, err := transport.Dial("tcp://localhost:" + port)
+	assert.NoError(t, err)
+
+	connect := packet.NewConnect()
+	connect.KeepAlive = 0
+
+	err = conn.Send(connect, false)
+	assert.NoError(t, err)
+
+	pkt, err := conn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.CONNACK, pkt.Type())
+
+	start := time.Now()
+
+	pkt, err = conn.Receive()
+	assert.Nil(t, pkt)
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestMaxKeepAlive(t *testing.T) {
+	engine := NewEngine(NewMemoryBackend())
+	engine.MaxKeepAlive = time.Minute
+
+	port, quit, done := Run(engine, "tcp")
+
+	conn, err := transport.Dial("tcp://localhost:" + port)
+	assert.NoError(t, err)
+
+	connect := packet.NewConnect()
+	connect.KeepAlive = 120
+
+	connack := packet.NewConnack()
+	connack.ReturnCode = packet.NotAuthorized
+
+	err = flow.New().
+		Send(connect).
+		Receive(connack).
+		End().
+		Test(conn)
+	assert.NoError(t, err)
+
+	conn, err = transport.Dial("tcp://localhost:" + port)
+	assert.NoError(t, err)
+
+	connect.KeepAlive = 60
+
+	err = flow.New().
+		Send(connect).
+		Receive(packet.NewConnack()).
+		Send(packet.NewDisconnect()).
+		End().
+		Test(conn)
+	assert.NoError(t, err)
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestMinKeepAlive(t *testing.T) {
+	backend := NewMemoryBackend()
+
+	keepAlive := make(chan time.Duration, 1)
+	backend.Logger = func(e LogEvent, c *Client, pkt packet.Generic, msg *packet.Message, err error) {
+		if e == PacketSent && pkt.Type() == packet.CONNACK {
+			keepAlive <- c.KeepAlive()
+		}
+	}
+
+	engine := NewEngine(backend)
+	engine.MinKeepAlive = time.Minute
+
+	port, quit, done := Run(engine, "tcp")
+
+	conn, err := transport.Dial("tcp://localhost:" + port)
+	assert.NoError(t, err)
+
+	connect := packet.NewConnect()
+	connect.KeepAlive = 1
+
+	err = flow.New().
+		Send(connect).
+		Receive(packet.NewConnack()).
+		Send(packet.NewDisconnect()).
+		End().
+		Test(conn)
+	assert.NoError(t, err)
+
+	assert.Equal(t, time.Minute, <-keepAlive)
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestClientIDGenerator(t *testing.T) {
+	backend := NewMemoryBackend()
+
+	id := make(chan string, 1)
+	backend.Logger = func(e LogEvent, c *Client, pkt packet.Generic, msg *packet.Message, err error) {
+		if e == PacketSent && pkt.Type() == packet.CONNACK {
+			id <- c.ID()
+		}
+	}
+
+	engine := NewEngine(backend)
+	engine.ClientIDGenerator = NewClientIDGenerator("auto-")
+
+	port, quit, done := Run(engine, "tcp")
+
+	c := client.New()
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.Fail(t, "should not be called")
+		return nil
+	}
+
+	cf, err := c.Connect(client.NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+	assert.False(t, cf.SessionPresent())
+
+	assignedID := <-id
+	assert.True(t, strings.HasPrefix(assignedID, "auto-"))
+	assert.Len(t, assignedID, 29)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestTopicParserStrict(t *testing.T) {
+	engine := NewEngine(NewMemoryBackend())
+	engine.TopicParser = &topic.Parser{Strict: true}
+
+	port, quit, done := Run(engine, "tcp")
+
+	c := client.New()
+	wait := make(chan struct{})
+
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.NoError(t, err)
+		assert.Equal(t, "test//topic/", msg.Topic)
+		close(wait)
+		return nil
+	}
+
+	cf, err := c.Connect(client.NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+
+	sf, err := c.Subscribe("test//+/", 0)
+	assert.NoError(t, err)
+	assert.NoError(t, sf.Wait(10*time.Second))
+	assert.Equal(t, []packet.QOS{0}, sf.ReturnCodes())
+
+	pf, err := c.Publish("test//topic/", []byte("test"), 0, false)
+	assert.NoError(t, err)
+	assert.NoError(t, pf.Wait(10*time.Second))
+
+	safeReceive(wait)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestTopicParserNormalize(t *testing.T) {
+	engine := NewEngine(NewMemoryBackend())
+	engine.TopicParser = &topic.Parser{}
+
+	port, quit, done := Run(engine, "tcp")
+
+	c := client.New()
+	wait := make(chan struct{})
+
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.NoError(t, err)
+		assert.Equal(t, "test/topic", msg.Topic)
+		close(wait)
+		return nil
+	}
+
+	cf, err := c.Connect(client.NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+
+	sf, err := c.Subscribe("test//+/", 0)
+	assert.NoError(t, err)
+	assert.NoError(t, sf.Wait(10*time.Second))
+	assert.Equal(t, []packet.QOS{0}, sf.ReturnCodes())
+
+	pf, err := c.Publish("test///topic//", []byte("test"), 0, false)
+	assert.NoError(t, err)
+	assert.NoError(t, pf.Wait(10*time.Second))
+
+	safeReceive(wait)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestTopicParserInvalidTopic(t *testing.T) {
+	engine := NewEngine(NewMemoryBackend())
+	engine.TopicParser = &topic.Parser{Strict: true, MaxDepth: 2}
+
+	port, quit, done := Run(engine, "tcp")
+
+	conn, err := transport.Dial("tcp://localhost:" + port)
+	assert.NoError(t, err)
+
+	publish := packet.NewPublish()
+	publish.Message.Topic = "a/b/c"
+
+	err = flow.New().
+		Send(packet.NewConnect()).
+		Receive(packet.NewConnack()).
+		Send(publish).
+		End().
+		Test(conn)
+	assert.NoError(t, err)
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestCompression(t *testing.T) {
+	backend := NewMemoryBackend()
+
+	engine := NewEngine(backend)
+	engine.Compression = &packet.Compression{
+		Compressor: packet.GzipCompressor{},
+		Prefix:     "z/",
+	}
+
+	port, quit, done := Run(engine, "tcp")
+
+	payload := []byte(strings.Repeat("test", 100))
+
+	c := client.New()
+	wait := make(chan struct{})
+
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.NoError(t, err)
+		assert.Equal(t, "z/test", msg.Topic)
+		assert.Equal(t, payload, msg.Payload)
+		close(wait)
+		return nil
+	}
+
+	config := client.NewConfig("tcp://localhost:" + port)
+	config.Compression = &packet.Compression{
+		Compressor: packet.GzipCompressor{},
+		Prefix:     "z/",
+	}
+
+	cf, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+
+	sf, err := c.Subscribe("z/test", 0)
+	assert.NoError(t, err)
+	assert.NoError(t, sf.Wait(10*time.Second))
+
+	pf, err := c.Publish("z/test", payload, 0, true)
+	assert.NoError(t, err)
+	assert.NoError(t, pf.Wait(10*time.Second))
+
+	safeReceive(wait)
+
+	msgs, err := backend.RetainedStore.Search("z/test")
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, payload, msgs[0].Payload)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestCompressionLimit(t *testing.T) {
+	engine := NewEngine(NewMemoryBackend())
+	engine.DefaultReadLimit = 1000
+	engine.Compression = &packet.Compression{
+		Compressor: packet.GzipCompressor{},
+	}
+
+	port, quit, done := Run(engine, "tcp")
+
+	conn, err := transport.Dial("tcp://localhost:" + port)
+	assert.NoError(t, err)
+
+	compression := &packet.Compression{
+		Compressor: packet.GzipCompressor{},
+	}
+
+	msg, err := compression.Compress(&packet.Message{
+		Topic:   "test",
+		Payload: make([]byte, 1001),
+	})
+	assert.NoError(t, err)
+
+	publish := packet.NewPublish()
+	publish.Message = *msg
+
+	err = flow.New().
+		Send(packet.NewConnect()).
+		Receive(packet.NewConnack()).
+		Send(publish).
+		End().
+		Test(conn)
+	assert.NoError(t, err)
+
+	close(quit)
+	safeReceive(done)
+}
@@ -0,0 +1,420 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/topic"
+)
+
+// ErrRetainedPayloadTooLarge is returned by a RetainedStore if the payload of
+// a message exceeds the configured maximum payload size.
+var ErrRetainedPayloadTooLarge = errors.New("retained payload too large")
+
+// ErrRetainedLimitReached is returned by a RetainedStore if storing a message
+// would exceed the configured maximum message count or size.
+var ErrRetainedLimitReached = errors.New("retained limit reached")
+
+// A RetainedStore stores retained messages for a backend.
+type RetainedStore interface {
+	// Store should save the supplied message as the retained message for its
+	// topic and replace any already retained message. If expiry is greater
+	// than zero, the message should not be returned anymore after the duration
+	// has passed.
+	Store(msg *packet.Message, expiry time.Duration) error
+
+	// Remove should delete the retained message for the supplied topic. The
+	// method should not return an error if no message is retained.
+	Remove(topic string) error
+
+	// Search should return all retained messages that match the supplied
+	// subscription filter.
+	Search(filter string) ([]*packet.Message, error)
+}
+
+type retainedMessage struct {
+	msg     *packet.Message
+	expires time.Time
+}
+
+func (r *retainedMessage) size() int64 {
+	return int64(len(r.msg.Topic) + len(r.msg.Payload))
+}
+
+func (r *retainedMessage) expired(now time.Time) bool {
+	return !r.expires.IsZero() && now.After(r.expires)
+}
+
+// A MemoryRetainedStore stores retained messages in memory.
+type MemoryRetainedStore struct {
+	// The maximum number of retained messages.
+	//
+	// Will default to unlimited if zero.
+	MaxMessages int
+
+	// The maximum total size of retained messages in bytes. The size of a
+	// message is calculated from its topic and payload lengths.
+	//
+	// Will default to unlimited if zero.
+	MaxBytes int64
+
+	// The maximum size of a single retained payload in bytes.
+	//
+	// Will default to unlimited if zero.
+	MaxPayloadSize int
+
+	tree  *topic.TypedTree[*retainedMessage]
+	count int
+	bytes int64
+	mutex sync.Mutex
+}
+
+// NewMemoryRetainedStore returns a new MemoryRetainedStore.
+func NewMemoryRetainedStore() *MemoryRetainedStore {
+	return &MemoryRetainedStore{
+		tree: topic.NewTypedTree[*retainedMessage](nil),
+	}
+}
+
+// Store will retain the supplied message.
+func (s *MemoryRetainedStore) Store(msg *packet.Message, expiry time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.retain(msg, expiry)
+}
+
+func (s *MemoryRetainedStore) retain(msg *packet.Message, expiry time.Duration) error {
+	// check payload size
+	if s.MaxPayloadSize > 0 && len(msg.Payload) > s.MaxPayloadSize {
+		return ErrRetainedPayloadTooLarge
+	}
+
+	// prepare retained message
+	rm := &retainedMessage{
+		msg: msg.Copy(),
+	}
+
+	// set expiry
+	if expiry > 0 {
+		rm.expires = time.Now().Add(expiry)
+	}
+
+	return s.store(rm)
+}
+
+func (s *MemoryRetainedStore) store(rm *retainedMessage) error {
+	// get existing message
+	existing := s.lookup(rm.msg.Topic)
+
+	// calculate new totals
+	count := s.count + 1
+	bytes := s.bytes + rm.size()
+	if existing != nil {
+		count--
+		bytes -= existing.size()
+	}
+
+	// purge expired messages and recalculate if a limit would be exceeded
+	if s.exceeds(count, bytes) {
+		s.purge(time.Now())
+
+		existing = s.lookup(rm.msg.Topic)
+		count = s.count + 1
+		bytes = s.bytes + rm.size()
+		if existing != nil {
+			count--
+			bytes -= existing.size()
+		}
+
+		// check limits again
+		if s.exceeds(count, bytes) {
+			return ErrRetainedLimitReached
+		}
+	}
+
+	// replace message
+	s.tree.Set(rm.msg.Topic, rm)
+	s.count = count
+	s.bytes = bytes
+
+	return nil
+}
+
+// restore will add the retained message without enforcing the limits
+func (s *MemoryRetainedStore) restore(rm *retainedMessage) {
+	s.tree.Set(rm.msg.Topic, rm)
+	s.count++
+	s.bytes += rm.size()
+}
+
+// Remove will remove the retained message for the supplied topic.
+func (s *MemoryRetainedStore) Remove(topic string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.remove(topic)
+
+	return nil
+}
+
+func (s *MemoryRetainedStore) remove(topic string) {
+	// get existing message
+	existing := s.lookup(topic)
+	if existing == nil {
+		return
+	}
+
+	// remove message
+	s.tree.Empty(topic)
+	s.count--
+	s.bytes -= existing.size()
+}
+
+// Search will return all retained messages that match the supplied filter.
+// Expired messages are removed from the store.
+func (s *MemoryRetainedStore) Search(filter string) ([]*packet.Message, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.search(filter), nil
+}
+
+func (s *MemoryRetainedStore) search(filter string) []*packet.Message {
+	// get current time
+	now := time.Now()
+
+	// prepare result
+	var list []*packet.Message
+
+	// collect messages
+	for _, rm := range s.tree.Search(filter) {
+		// remove expired messages
+		if rm.expired(now) {
+			s.remove(rm.msg.Topic)
+			continue
+		}
+
+		list = append(list, rm.msg.Copy())
+	}
+
+	return list
+}
+
+// Count will return the number of currently retained messages.
+func (s *MemoryRetainedStore) Count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.count
+}
+
+// Bytes will return the total size of the currently retained messages.
+func (s *MemoryRetainedStore) Bytes() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.bytes
+}
+
+func (s *MemoryRetainedStore) lookup(topic string) *retainedMessage {
+	values := s.tree.Get(topic)
+	if len(values) > 0 {
+		return values[0]
+	}
+
+	return nil
+}
+
+func (s *MemoryRetainedStore) exceeds(count int, bytes int64) bool {
+	return (s.MaxMessages > 0 && count > s.MaxMessages) ||
+		(s.MaxBytes > 0 && bytes > s.MaxBytes)
+}
+
+func (s *MemoryRetainedStore) purge(now time.Time) {
+	for _, rm := range s.tree.All() {
+		if rm.expired(now) {
+			s.remove(rm.msg.Topic)
+		}
+	}
+}
+
+type retainedRecord struct {
+	Topic   string    `json:"topic"`
+	Payload []byte    `json:"payload"`
+	QOS     byte      `json:"qos"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// A FileRetainedStore keeps retained messages in memory and persists them to
+// a file on every change. The limits of the embedded MemoryRetainedStore
+// apply as well.
+type FileRetainedStore struct {
+	*MemoryRetainedStore
+
+	path string
+}
+
+// NewFileRetainedStore returns a new FileRetainedStore that persists retained
+// messages to the specified file. Already persisted messages are loaded if the
+// file exists.
+func NewFileRetainedStore(path string) (*FileRetainedStore, error) {
+	// prepare store
+	store := &FileRetainedStore{
+		MemoryRetainedStore: NewMemoryRetainedStore(),
+		path:                path,
+	}
+
+	// read file
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// decode records
+	var records []retainedRecord
+	err = json.Unmarshal(data, &records)
+	if err != nil {
+		return nil, err
+	}
+
+	// get current time
+	now := time.Now()
+
+	// restore messages
+	for _, record := range records {
+		rm := &retainedMessage{
+			msg: &packet.Message{
+				Topic:   record.Topic,
+				Payload: record.Payload,
+				QOS:     packet.QOS(record.QOS),
+				Retain:  true,
+			},
+			expires: record.Expires,
+		}
+
+		// skip expired messages
+		if rm.expired(now) {
+			continue
+		}
+
+		// limits are not enforced on restore
+		store.restore(rm)
+	}
+
+	return store, nil
+}
+
+// Store will retain the supplied message and persist the store. The message is
+// not retained if the store cannot be persisted.
+func (s *FileRetainedStore) Store(msg *packet.Message, expiry time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// get existing message
+	existing := s.lookup(msg.Topic)
+
+	// retain message
+	err := s.retain(msg, expiry)
+	if err != nil {
+		return err
+	}
+
+	// persist store
+	err = s.persist()
+	if err != nil {
+		// restore existing message
+		s.remove(msg.Topic)
+		if existing != nil {
+			s.restore(existing)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Remove will remove the retained message for the supplied topic and persist
+// the store. The message is kept if the store cannot be persisted.
+func (s *FileRetainedStore) Remove(topic string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// get existing message
+	existing := s.lookup(topic)
+	if existing == nil {
+		return nil
+	}
+
+	// remove message
+	s.remove(topic)
+
+	// persist store
+	err := s.persist()
+	if err != nil {
+		// restore existing message
+		s.restore(existing)
+
+		return err
+	}
+
+	return nil
+}
+
+// Search will return all retained messages that match the supplied filter.
+// Expired messages are removed from the store and the store is persisted.
+func (s *FileRetainedStore) Search(filter string) ([]*packet.Message, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// search messages
+	count := s.count
+	list := s.search(filter)
+
+	// persist store if messages expired, a failure is ignored as expired
+	// messages are skipped when the file is loaded
+	if s.count != count {
+		_ = s.persist()
+	}
+
+	return list, nil
+}
+
+// persist will write the store to the file, the mutex must be held
+func (s *FileRetainedStore) persist() error {
+	// prepare records
+	var records []retainedRecord
+	for _, rm := range s.tree.All() {
+		records = append(records, retainedRecord{
+			Topic:   rm.msg.Topic,
+			Payload: rm.msg.Payload,
+			QOS:     byte(rm.msg.QOS),
+			Expires: rm.expires,
+		})
+	}
+
+	// encode records
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	// write to temporary file
+	tmp := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	// atomically replace file
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,242 @@
+package broker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/packet"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryRetainedStore(t *testing.T) {
+	store := NewMemoryRetainedStore()
+
+	msg := &packet.Message{Topic: "foo/bar", Payload: []byte("baz"), Retain: true}
+
+	err := store.Store(msg, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, store.Count())
+	assert.Equal(t, int64(10), store.Bytes())
+
+	msgs, err := store.Search("foo/+")
+	assert.NoError(t, err)
+	assert.Equal(t, []*packet.Message{msg}, msgs)
+
+	err = store.Store(&packet.Message{Topic: "foo/bar", Payload: []byte("quz!")}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, store.Count())
+	assert.Equal(t, int64(11), store.Bytes())
+
+	err = store.Remove("foo/bar")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, store.Count())
+	assert.Equal(t, int64(0), store.Bytes())
+
+	msgs, err = store.Search("#")
+	assert.NoError(t, err)
+	assert.Empty(t, msgs)
+}
+
+func TestMemoryRetainedStoreLimits(t *testing.T) {
+	store := NewMemoryRetainedStore()
+	store.MaxMessages = 2
+	store.MaxBytes = 9
+	store.MaxPayloadSize = 4
+
+	err := store.Store(&packet.Message{Topic: "a", Payload: []byte("12345")}, 0)
+	assert.Equal(t, ErrRetainedPayloadTooLarge, err)
+
+	err = store.Store(&packet.Message{Topic: "a", Payload: []byte("1")}, 0)
+	assert.NoError(t, err)
+
+	err = store.Store(&packet.Message{Topic: "b", Payload: []byte("1")}, 0)
+	assert.NoError(t, err)
+
+	err = store.Store(&packet.Message{Topic: "c", Payload: []byte("1")}, 0)
+	assert.Equal(t, ErrRetainedLimitReached, err)
+
+	err = store.Store(&packet.Message{Topic: "b", Payload: []byte("1234")}, 0)
+	assert.NoError(t, err)
+
+	err = store.Store(&packet.Message{Topic: "a", Payload: []byte("1234")}, 0)
+	assert.Equal(t, ErrRetainedLimitReached, err)
+	assert.Equal(t, 2, store.Count())
+	assert.Equal(t, int64(7), store.Bytes())
+}
+
+func TestMemoryRetainedStoreExpiry(t *testing.T) {
+	store := NewMemoryRetainedStore()
+	store.MaxMessages = 1
+
+	err := store.Store(&packet.Message{Topic: "a", Payload: []byte("1")}, 10*time.Millisecond)
+	assert.NoError(t, err)
+
+	msgs, err := store.Search("a")
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+
+	time.Sleep(20 * time.Millisecond)
+
+	err = store.Store(&packet.Message{Topic: "b", Payload: []byte("1")}, 0)
+	assert.NoError(t, err)
+
+	msgs, err = store.Search("#")
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, "b", msgs[0].Topic)
+}
+
+func TestFileRetainedStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomqtt")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "retained.json")
+
+	store, err := NewFileRetainedStore(path)
+	assert.NoError(t, err)
+
+	err = store.Store(&packet.Message{Topic: "a", Payload: []byte("1"), QOS: 1, Retain: true}, 0)
+	assert.NoError(t, err)
+
+	err = store.Store(&packet.Message{Topic: "b", Payload: []byte("2"), Retain: true}, 0)
+	assert.NoError(t, err)
+
+	err = store.Remove("b")
+	assert.NoError(t, err)
+
+	store, err = NewFileRetainedStore(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, store.Count())
+
+	msgs, err := store.Search("#")
+	assert.NoError(t, err)
+	assert.Equal(t, []*packet.Message{
+		{Topic: "a", Payload: []byte("1"), QOS: 1, Retain: true},
+	}, msgs)
+}
+
+func TestFileRetainedStorePersistError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomqtt")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "retained.json")
+
+	store, err := NewFileRetainedStore(path)
+	assert.NoError(t, err)
+
+	err = store.Store(&packet.Message{Topic: "a", Payload: []byte("1"), Retain: true}, 0)
+	assert.NoError(t, err)
+
+	store.path = filepath.Join(dir, "missing", "retained.json")
+
+	err = store.Store(&packet.Message{Topic: "a", Payload: []byte("2"), Retain: true}, 0)
+	assert.Error(t, err)
+
+	err = store.Store(&packet.Message{Topic: "b", Payload: []byte("3"), Retain: true}, 0)
+	assert.Error(t, err)
+
+	err = store.Remove("a")
+	assert.Error(t, err)
+
+	assert.Equal(t, 1, store.Count())
+	assert.Equal(t, int64(2), store.Bytes())
+
+	msgs, err := store.Search("#")
+	assert.NoError(t, err)
+	assert.Equal(t, []*packet.Message{
+		{Topic: "a", Payload: []byte("1"), Retain: true},
+	}, msgs)
+}
+
+func TestFileRetainedStoreExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomqtt")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "retained.json")
+
+	store, err := NewFileRetainedStore(path)
+	assert.NoError(t, err)
+
+	err = store.Store(&packet.Message{Topic: "a", Payload: []byte("1"), Retain: true}, 0)
+	assert.NoError(t, err)
+
+	err = store.Store(&packet.Message{Topic: "b", Payload: []byte("2"), Retain: true}, 10*time.Millisecond)
+	assert.NoError(t, err)
+
+	time.Sleep(20 * time.Millisecond)
+
+	msgs, err := store.Search("#")
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), `"topic":"b"`)
+}
+
+func TestMemoryBackendRetainedLimit(t *testing.T) {
+	store := NewMemoryRetainedStore()
+	store.MaxPayloadSize = 1
+
+	var rejected []error
+	var mutex sync.Mutex
+
+	backend := NewMemoryBackend()
+	backend.RetainedStore = store
+	backend.Logger = func(e LogEvent, c *Client, pkt packet.Generic, msg *packet.Message, err error) {
+		if e == MessageNotRetained {
+			mutex.Lock()
+			rejected = append(rejected, err)
+			mutex.Unlock()
+		}
+	}
+
+	port, quit, done := Run(NewEngine(backend), "tcp")
+
+	received := make(chan struct{})
+
+	c := client.New()
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.NoError(t, err)
+		assert.Equal(t, "a", msg.Topic)
+		assert.Equal(t, []byte("12"), msg.Payload)
+		close(received)
+		return nil
+	}
+
+	cf, err := c.Connect(client.NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+
+	sf, err := c.Subscribe("a", 1)
+	assert.NoError(t, err)
+	assert.NoError(t, sf.Wait(10*time.Second))
+
+	pf, err := c.Publish("a", []byte("12"), 1, true)
+	assert.NoError(t, err)
+	assert.NoError(t, pf.Wait(10*time.Second))
+
+	safeReceive(received)
+
+	assert.Equal(t, 0, store.Count())
+
+	mutex.Lock()
+	assert.Equal(t, []error{ErrRetainedPayloadTooLarge}, rejected)
+	mutex.Unlock()
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	close(quit)
+
+	safeReceive(done)
+}
@@ -0,0 +1,141 @@
+package broker
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// A SlogLogger emits log events as structured log/slog records. It can be used
+// with the MemoryBackend by assigning its Log method to the Logger field.
+type SlogLogger struct {
+	// The logger that receives the records.
+	Logger *slog.Logger
+
+	// Levels can be set to override the level of individual events. Errors are
+	// logged with slog.LevelError, packet traffic with slog.LevelDebug and all
+	// other events with slog.LevelInfo by default.
+	Levels map[LogEvent]slog.Level
+
+	// Sampling can be set to only log every nth occurrence of an event, which
+	// is useful for high volume events like PacketReceived and PacketSent.
+	Sampling map[LogEvent]int
+
+	counters map[LogEvent]int
+	mutex    sync.Mutex
+}
+
+// NewSlogLogger returns a new SlogLogger that uses the specified logger.
+func NewSlogLogger(logger *slog.Logger) *SlogLogger {
+	return &SlogLogger{
+		Logger: logger,
+	}
+}
+
+// Log will emit a record for the supplied event.
+func (l *SlogLogger) Log(event LogEvent, client *Client, pkt packet.Generic, msg *packet.Message, err error) {
+	// get level
+	level := l.level(event, err)
+
+	// check if enabled
+	if !l.Logger.Enabled(context.Background(), level) {
+		return
+	}
+
+	// check sampling
+	if !l.sample(event) {
+		return
+	}
+
+	// prepare attributes
+	attrs := []slog.Attr{
+		slog.String("event", string(event)),
+	}
+
+	// add client attributes
+	if client != nil {
+		attrs = append(attrs, slog.String("client_id", client.ID()))
+
+		if conn := client.Conn(); conn != nil && conn.RemoteAddr() != nil {
+			attrs = append(attrs, slog.String("remote_addr", conn.RemoteAddr().String()))
+		}
+	}
+
+	// add packet attributes
+	if pkt != nil {
+		attrs = append(attrs, slog.String("packet_type", pkt.Type().String()))
+
+		if id, ok := packet.GetID(pkt); ok && id > 0 {
+			attrs = append(attrs, slog.Int("packet_id", int(id)))
+		}
+
+		// use message of publish packets
+		if publish, ok := pkt.(*packet.Publish); ok && msg == nil {
+			msg = &publish.Message
+		}
+	}
+
+	// add message attributes
+	if msg != nil {
+		attrs = append(attrs,
+			slog.String("topic", msg.Topic),
+			slog.Int("qos", int(msg.QOS)),
+			slog.Int("payload_size", len(msg.Payload)),
+		)
+	}
+
+	// add error
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+
+	l.Logger.LogAttrs(context.Background(), level, string(event), attrs...)
+}
+
+func (l *SlogLogger) level(event LogEvent, err error) slog.Level {
+	// check overrides
+	if level, ok := l.Levels[event]; ok {
+		return level
+	}
+
+	// map events
+	switch event {
+	case TransportError, SessionError, BackendError, ClientError:
+		return slog.LevelError
+	case MessageNotRetained:
+		return slog.LevelWarn
+	case PacketReceived, PacketSent:
+		return slog.LevelDebug
+	}
+
+	// check error
+	if err != nil {
+		return slog.LevelError
+	}
+
+	return slog.LevelInfo
+}
+
+func (l *SlogLogger) sample(event LogEvent) bool {
+	// get rate
+	rate := l.Sampling[event]
+	if rate <= 1 {
+		return true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// prepare counters
+	if l.counters == nil {
+		l.counters = make(map[LogEvent]int)
+	}
+
+	// get and increment counter
+	n := l.counters[event]
+	l.counters[event] = n + 1
+
+	return n%rate == 0
+}
@@ -0,0 +1,57 @@
+package broker
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/256dpi/gomqtt/packet"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger := NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	})))
+
+	publish := packet.NewPublish()
+	publish.ID = 7
+	publish.Message = packet.Message{Topic: "foo", Payload: []byte("bar"), QOS: 1}
+
+	logger.Log(PacketReceived, nil, publish, nil, nil)
+	logger.Log(BackendError, nil, nil, nil, errors.New("failed"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `level=DEBUG msg="packet received" event="packet received" packet_type=Publish packet_id=7 topic=foo qos=1 payload_size=3`)
+	assert.Contains(t, lines[1], `level=ERROR msg="backend error" event="backend error" error=failed`)
+}
+
+func TestSlogLoggerSampling(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger := NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	})))
+	logger.Sampling = map[LogEvent]int{
+		PacketReceived: 10,
+	}
+	logger.Levels = map[LogEvent]slog.Level{
+		NewConnection: slog.LevelWarn,
+	}
+
+	for i := 0; i < 25; i++ {
+		logger.Log(PacketReceived, nil, packet.NewPingreq(), nil, nil)
+	}
+
+	logger.Log(NewConnection, nil, nil, nil, nil)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 4)
+	assert.Contains(t, lines[3], `level=WARN msg="new connection"`)
+}
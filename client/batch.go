@@ -0,0 +1,206 @@
+package client
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/256dpi/gomqtt/client/future"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/session"
+)
+
+// A BatchFuture is returned by PublishBatch.
+type BatchFuture interface {
+	GenericFuture
+
+	// Failed will return the indexes of the messages that could not be
+	// published or have not been acknowledged before the connection closed.
+	Failed() []int
+}
+
+// a publish batch tracks the acknowledgements of batched publishes
+type publishBatch struct {
+	*future.Future
+
+	pending  int
+	indexes  map[packet.ID]int
+	failed   []int
+	canceled bool
+	mutex    sync.Mutex
+}
+
+func newPublishBatch() *publishBatch {
+	return &publishBatch{
+		Future:  future.New(),
+		pending: 1,
+		indexes: make(map[packet.ID]int),
+	}
+}
+
+// add will add a publish that awaits acknowledgement
+func (b *publishBatch) add(id packet.ID, index int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.pending++
+	b.indexes[id] = index
+}
+
+// reject will mark the message as failed
+func (b *publishBatch) reject(index int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failed = append(b.failed, index)
+}
+
+// ack will mark the publish as acknowledged
+func (b *publishBatch) ack(id packet.ID) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// remove index
+	delete(b.indexes, id)
+
+	b.release()
+}
+
+// done will complete the future if all publishes have been acknowledged
+func (b *publishBatch) done() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.release()
+}
+
+// release will decrement the pending counter and complete the future if no
+// more publishes are pending
+func (b *publishBatch) release() {
+	b.pending--
+	if b.pending == 0 {
+		b.Complete()
+	}
+}
+
+// fail will mark the publish as failed and cancel the future
+func (b *publishBatch) fail(id packet.ID) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// mark message as failed
+	if index, ok := b.indexes[id]; ok {
+		delete(b.indexes, id)
+		b.failed = append(b.failed, index)
+	}
+
+	// cancel future once
+	if !b.canceled {
+		b.canceled = true
+		b.Cancel()
+	}
+}
+
+func (b *publishBatch) Failed() []int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// sort indexes
+	failed := make([]int, len(b.failed))
+	copy(failed, b.failed)
+	sort.Ints(failed)
+
+	return failed
+}
+
+// PublishBatch will send Publish packets for all passed messages and flush the
+// connection once. It will return a single BatchFuture that gets completed once
+// the quality of service flows of all messages have been completed. Messages
+// that cannot be published (e.g. if the inflight window is full and the
+// InflightFail policy is used) are reported using Failed. If the connection
+// closes before all messages have been acknowledged, the future gets canceled.
+// If the InflightBlock policy is used, the already prepared messages are sent
+// before waiting for space in the window, which may flush the connection
+// multiple times.
+//
+// Note: Batched publishes are not tracked by the future store and thus not
+// awaited by Disconnect.
+func (c *Client) PublishBatch(msgs []*packet.Message) (BatchFuture, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// check if connected
+	if atomic.LoadUint32(&c.state) != clientConnected {
+		return nil, ErrClientNotConnected
+	}
+
+	// prepare batch
+	batch := newPublishBatch()
+
+	// prepare publishes
+	publishes := make([]*packet.Publish, 0, len(msgs))
+
+	// send packets and flush with the last packet
+	flush := func() error {
+		for i, publish := range publishes {
+			err := c.send(publish, i < len(publishes)-1)
+			if err != nil {
+				return err
+			}
+		}
+
+		publishes = publishes[:0]
+
+		return nil
+	}
+
+	for i, msg := range msgs {
+		// send prepared publishes before waiting for space in the window
+		if msg.QOS > 0 && c.inflight.blocks() {
+			err := flush()
+			if err != nil {
+				return nil, c.cleanup(err, false, false)
+			}
+		}
+
+		// prepare publish packet
+		publish, err := c.preparePublish(msg)
+		if err != nil {
+			batch.reject(i)
+			continue
+		}
+
+		// queue qos 0 publish
+		if publish.Message.QOS == 0 {
+			publishes = append(publishes, publish)
+			continue
+		}
+
+		// store packet
+		err = c.Session.SavePacket(session.Outgoing, publish)
+		if err != nil {
+			return nil, c.cleanup(err, true, false)
+		}
+
+		// track publish
+		batch.add(publish.ID, i)
+		c.batches.Store(publish.ID, batch)
+
+		// add to inflight window and queue the publish if it has not been
+		// queued by the window
+		if c.inflight.add(publish) {
+			publishes = append(publishes, publish)
+		}
+	}
+
+	// send remaining packets
+	err := flush()
+	if err != nil {
+		return nil, c.cleanup(err, false, false)
+	}
+
+	// complete batch if all publishes have been acknowledged
+	batch.done()
+
+	return batch, nil
+}
@@ -0,0 +1,241 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/client/future"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/transport/flow"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func batchMessages() []*packet.Message {
+	return []*packet.Message{
+		{Topic: "test", Payload: []byte("test"), QOS: 0},
+		{Topic: "test", Payload: []byte("test"), QOS: 1},
+		{Topic: "test", Payload: []byte("test"), QOS: 2},
+	}
+}
+
+func TestClientPublishBatch(t *testing.T) {
+	msgs := batchMessages()
+
+	publish0 := packet.NewPublish()
+	publish0.Message = *msgs[0]
+
+	publish1 := packet.NewPublish()
+	publish1.Message = *msgs[1]
+	publish1.ID = 1
+
+	publish2 := packet.NewPublish()
+	publish2.Message = *msgs[2]
+	publish2.ID = 2
+
+	puback := packet.NewPuback()
+	puback.ID = 1
+
+	pubrec := packet.NewPubrec()
+	pubrec.ID = 2
+
+	pubrel := packet.NewPubrel()
+	pubrel.ID = 2
+
+	pubcomp := packet.NewPubcomp()
+	pubcomp.ID = 2
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(publish0, publish1, publish2).
+		Send(puback).
+		Send(pubrec).
+		Receive(pubrel).
+		Send(pubcomp).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	batchFuture, err := c.PublishBatch(msgs)
+	assert.NoError(t, err)
+	assert.NoError(t, batchFuture.Wait(1*time.Second))
+	assert.Empty(t, batchFuture.Failed())
+
+	statistics := c.Statistics()
+	assert.Equal(t, uint64(3), statistics.MessagesSent)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientPublishBatchInflightFail(t *testing.T) {
+	publishes, pubacks := inflightPackets(2)
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(publishes[0]).
+		Send(pubacks[0]).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.MaxInflight = 1
+	config.InflightPolicy = InflightFail
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	batchFuture, err := c.PublishBatch([]*packet.Message{
+		&publishes[0].Message,
+		&publishes[1].Message,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, batchFuture.Wait(1*time.Second))
+	assert.Equal(t, []int{1}, batchFuture.Failed())
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientPublishBatchInflightBlock(t *testing.T) {
+	publishes, pubacks := inflightPackets(3)
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(publishes[0]).
+		Send(pubacks[0]).
+		Receive(publishes[1]).
+		Send(pubacks[1]).
+		Receive(publishes[2]).
+		Send(pubacks[2]).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.MaxInflight = 1
+	config.InflightPolicy = InflightBlock
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	batchFuture, err := c.PublishBatch([]*packet.Message{
+		&publishes[0].Message,
+		&publishes[1].Message,
+		&publishes[2].Message,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, batchFuture.Wait(1*time.Second))
+	assert.Empty(t, batchFuture.Failed())
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientPublishBatchConnectionClose(t *testing.T) {
+	msgs := batchMessages()
+
+	publish0 := packet.NewPublish()
+	publish0.Message = *msgs[0]
+
+	publish1 := packet.NewPublish()
+	publish1.Message = *msgs[1]
+	publish1.ID = 1
+
+	publish2 := packet.NewPublish()
+	publish2.Message = *msgs[2]
+	publish2.ID = 2
+
+	puback := packet.NewPuback()
+	puback.ID = 1
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(publish0, publish1, publish2).
+		Send(puback).
+		Close()
+
+	done, port := fakeBroker(t, broker)
+
+	wait := make(chan struct{})
+
+	c := New()
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.Nil(t, msg)
+		assert.Error(t, err)
+		close(wait)
+		return nil
+	}
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	batchFuture, err := c.PublishBatch(msgs)
+	assert.NoError(t, err)
+	assert.Equal(t, future.ErrCanceled, batchFuture.Wait(1*time.Second))
+	assert.Equal(t, []int{2}, batchFuture.Failed())
+
+	safeReceive(wait)
+	safeReceive(done)
+}
+
+func BenchmarkClientPublishBatch(b *testing.B) {
+	c := New()
+
+	connectFuture, err := c.Connect(NewConfig("mqtt://0.0.0.0"))
+	if err != nil {
+		panic(err)
+	}
+
+	err = connectFuture.Wait(1 * time.Second)
+	if err != nil {
+		panic(err)
+	}
+
+	msgs := make([]*packet.Message, 100)
+	for i := range msgs {
+		msgs[i] = &packet.Message{Topic: "test", Payload: []byte("test")}
+	}
+
+	for i := 0; i < b.N; i += len(msgs) {
+		_, err := c.PublishBatch(msgs)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	err = c.Disconnect()
+	if err != nil {
+		panic(err)
+	}
+}
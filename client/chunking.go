@@ -0,0 +1,396 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/client/future"
+	"github.com/256dpi/gomqtt/packet"
+
+	"gopkg.in/tomb.v2"
+)
+
+// DefaultChunkingLimit is the maximum size of a reassembled payload that is
+// used if no limit has been configured.
+const DefaultChunkingLimit = 64 << 20
+
+// DefaultChunkingAssemblies is the maximum number of incomplete messages that
+// is used if no maximum has been configured.
+const DefaultChunkingAssemblies = 100
+
+// ErrChunkingDisabled is emitted by PublishChunked if chunking has not been
+// configured.
+var ErrChunkingDisabled = errors.New("chunking disabled")
+
+// ErrChunkingTimeout is emitted if a chunked message has not been received
+// completely within the timeout.
+var ErrChunkingTimeout = errors.New("chunking timeout")
+
+// ErrChunkingLimitExceeded is emitted if a chunked message exceeds the limit.
+var ErrChunkingLimitExceeded = errors.New("chunking limit exceeded")
+
+// ErrChunkingTooManyAssemblies is emitted if a chunked message is dropped
+// because the maximum number of incomplete messages has been reached.
+var ErrChunkingTooManyAssemblies = errors.New("chunking too many assemblies")
+
+// ErrChunkingChecksumMismatch is emitted if a reassembled payload does not
+// match the checksum of the manifest.
+var ErrChunkingChecksumMismatch = errors.New("chunking checksum mismatch")
+
+// Chunking defines how large payloads are split into chunks and reassembled.
+// A chunked message is published as a manifest followed by the numbered chunks
+// as QOS 1 messages to the same topic. Subscribers reassemble the chunks, which
+// may arrive in any order, and verify the payload using the checksum in the
+// manifest.
+//
+// Note: Incomplete messages are kept in memory and thus lost if the process
+// restarts. Chunked messages cannot be retained.
+type Chunking struct {
+	// The maximum size of a chunk payload.
+	Size int
+
+	// The time after which incomplete messages are dropped. Expired messages
+	// are checked for periodically while the service is running.
+	//
+	// Will default to one minute if zero.
+	Timeout time.Duration
+
+	// The maximum size of a reassembled payload including the headers of the
+	// chunks.
+	//
+	// Will default to DefaultChunkingLimit if zero.
+	Limit int64
+
+	// The maximum number of incomplete messages. Chunks of further messages
+	// are dropped until incomplete messages complete or expire.
+	//
+	// Will default to DefaultChunkingAssemblies if zero.
+	MaxAssemblies int
+}
+
+var chunkMagic = []byte("GMQC")
+
+const (
+	chunkManifest byte = 'M'
+	chunkData     byte = 'D'
+)
+
+const (
+	chunkIDLen       = 16
+	chunkHeaderLen   = 4 + 1 + chunkIDLen + 4
+	chunkManifestLen = chunkHeaderLen + 8 + sha256.Size
+)
+
+type chunkID [chunkIDLen]byte
+
+// encodes the header of a manifest or chunk
+func encodeChunkHeader(buf []byte, kind byte, id chunkID, num uint32) {
+	copy(buf, chunkMagic)
+	buf[4] = kind
+	copy(buf[5:], id[:])
+	binary.BigEndian.PutUint32(buf[5+chunkIDLen:], num)
+}
+
+// decodes the header of a manifest or chunk
+func decodeChunkHeader(payload []byte) (byte, chunkID, uint32, bool) {
+	// check length and magic
+	if len(payload) < chunkHeaderLen || !bytes.Equal(payload[:4], chunkMagic) {
+		return 0, chunkID{}, 0, false
+	}
+
+	// check kind
+	kind := payload[4]
+	if kind != chunkManifest && kind != chunkData {
+		return 0, chunkID{}, 0, false
+	}
+
+	// get id and number
+	var id chunkID
+	copy(id[:], payload[5:])
+	num := binary.BigEndian.Uint32(payload[5+chunkIDLen:])
+
+	return kind, id, num, true
+}
+
+// returns the payloads of the manifest and the chunks of the payload
+func splitChunks(payload []byte, size int) [][]byte {
+	// generate id
+	var id chunkID
+	_, err := rand.Read(id[:])
+	if err != nil {
+		panic(err)
+	}
+
+	// get number of chunks
+	count := (len(payload) + size - 1) / size
+
+	// prepare manifest
+	manifest := make([]byte, chunkManifestLen)
+	encodeChunkHeader(manifest, chunkManifest, id, uint32(count))
+	binary.BigEndian.PutUint64(manifest[chunkHeaderLen:], uint64(len(payload)))
+	checksum := sha256.Sum256(payload)
+	copy(manifest[chunkHeaderLen+8:], checksum[:])
+
+	// prepare payloads
+	payloads := make([][]byte, 0, count+1)
+	payloads = append(payloads, manifest)
+
+	// prepare chunks
+	for i := 0; i < count; i++ {
+		// get data
+		data := payload[i*size : min((i+1)*size, len(payload))]
+
+		// prepare chunk
+		chunk := make([]byte, chunkHeaderLen+len(data))
+		encodeChunkHeader(chunk, chunkData, id, uint32(i))
+		copy(chunk[chunkHeaderLen:], data)
+		payloads = append(payloads, chunk)
+	}
+
+	return payloads
+}
+
+// PublishChunked will split the payload into chunks according to the Chunking
+// configuration and publish a manifest and the chunks as QOS 1 messages. It
+// will return a future that gets completed once all chunks have been
+// acknowledged. If chunking has not been configured, ErrChunkingDisabled is
+// reported using the ErrorCallback and the returned future is canceled.
+func (s *Service) PublishChunked(topic string, payload []byte) GenericFuture {
+	// check config
+	if s.Chunking == nil || s.Chunking.Size <= 0 {
+		s.err("Chunking", ErrChunkingDisabled)
+
+		// cancel future
+		f := future.New()
+		f.Cancel()
+
+		return f
+	}
+
+	// split payload
+	payloads := splitChunks(payload, s.Chunking.Size)
+
+	// publish manifest and chunks
+	futures := make([]*future.Future, 0, len(payloads))
+	for _, payload := range payloads {
+		futures = append(futures, s.PublishMessage(&packet.Message{
+			Topic:   topic,
+			Payload: payload,
+			QOS:     1,
+		}).(*future.Future))
+	}
+
+	return future.All(futures...)
+}
+
+// periodically drops expired assemblies until the service is stopped
+func (s *Service) expirer() error {
+	// prepare ticker
+	ticker := time.NewTicker(s.reassembler.timeout() / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := s.reassembler.check()
+			if err != nil {
+				s.err("Chunking", err)
+			}
+		case <-s.tomb.Dying():
+			return tomb.ErrDying
+		}
+	}
+}
+
+// an assembly collects the chunks of a message
+type assembly struct {
+	created  time.Time
+	manifest bool
+	count    uint32
+	size     uint64
+	checksum [sha256.Size]byte
+	chunks   map[uint32][]byte
+	length   int64
+}
+
+// the reassembler reassembles chunked messages
+type reassembler struct {
+	chunking   *Chunking
+	assemblies map[chunkID]*assembly
+	finished   map[chunkID]time.Time
+	mutex      sync.Mutex
+}
+
+func newReassembler(chunking *Chunking) *reassembler {
+	return &reassembler{
+		chunking:   chunking,
+		assemblies: make(map[chunkID]*assembly),
+		finished:   make(map[chunkID]time.Time),
+	}
+}
+
+// process will add the message to its assembly and return the reassembled
+// message once all chunks have been received. Messages that are not chunked
+// are returned as is. The returned error should be reported and is not fatal.
+func (r *reassembler) process(msg *packet.Message) (*packet.Message, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// drop expired assemblies
+	err := r.expire()
+
+	// decode header
+	kind, id, num, ok := decodeChunkHeader(msg.Payload)
+	if !ok {
+		return msg, err
+	}
+
+	// ignore redelivered chunks of completed and trailing chunks of rejected
+	// messages
+	if _, ok := r.finished[id]; ok {
+		return nil, err
+	}
+
+	// get limit
+	limit := r.chunking.Limit
+	if limit <= 0 {
+		limit = DefaultChunkingLimit
+	}
+
+	// get maximum
+	maxAssemblies := r.chunking.MaxAssemblies
+	if maxAssemblies <= 0 {
+		maxAssemblies = DefaultChunkingAssemblies
+	}
+
+	// get assembly
+	a, ok := r.assemblies[id]
+	if !ok {
+		// check maximum
+		if len(r.assemblies) >= maxAssemblies {
+			r.finished[id] = time.Now()
+			return nil, ErrChunkingTooManyAssemblies
+		}
+
+		a = &assembly{
+			created: time.Now(),
+			chunks:  make(map[uint32][]byte),
+		}
+		r.assemblies[id] = a
+	}
+
+	// handle manifest or chunk
+	switch kind {
+	case chunkManifest:
+		// check length
+		if len(msg.Payload) != chunkManifestLen {
+			return nil, err
+		}
+
+		// set manifest
+		a.manifest = true
+		a.count = num
+		a.size = binary.BigEndian.Uint64(msg.Payload[chunkHeaderLen:])
+		copy(a.checksum[:], msg.Payload[chunkHeaderLen+8:])
+
+		// check limit
+		if a.size+uint64(a.count)*chunkHeaderLen > uint64(limit) {
+			delete(r.assemblies, id)
+			r.finished[id] = time.Now()
+			return nil, ErrChunkingLimitExceeded
+		}
+	case chunkData:
+		// ignore duplicates and invalid chunks
+		if _, ok := a.chunks[num]; ok || (a.manifest && num >= a.count) {
+			return nil, err
+		}
+
+		// add chunk, the header is counted to also limit the number of
+		// chunks that are received before the manifest
+		a.chunks[num] = msg.Payload[chunkHeaderLen:]
+		a.length += int64(len(msg.Payload))
+
+		// check limit
+		if a.length > limit {
+			delete(r.assemblies, id)
+			r.finished[id] = time.Now()
+			return nil, ErrChunkingLimitExceeded
+		}
+	}
+
+	// check if complete
+	if !a.manifest || uint32(len(a.chunks)) < a.count {
+		return nil, err
+	}
+
+	// remove assembly
+	delete(r.assemblies, id)
+	r.finished[id] = time.Now()
+
+	// join chunks
+	payload := make([]byte, 0, a.size)
+	for i := uint32(0); i < a.count; i++ {
+		payload = append(payload, a.chunks[i]...)
+	}
+
+	// verify payload
+	if uint64(len(payload)) != a.size || sha256.Sum256(payload) != a.checksum {
+		return nil, ErrChunkingChecksumMismatch
+	}
+
+	return &packet.Message{
+		Topic:   msg.Topic,
+		Payload: payload,
+		QOS:     msg.QOS,
+	}, err
+}
+
+// check will drop expired assemblies and return ErrChunkingTimeout if any
+// have been dropped
+func (r *reassembler) check() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.expire()
+}
+
+// returns the configured or default timeout
+func (r *reassembler) timeout() time.Duration {
+	// get timeout
+	timeout := r.chunking.Timeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+
+	return timeout
+}
+
+// drops expired assemblies and returns ErrChunkingTimeout if any have been
+// dropped
+func (r *reassembler) expire() error {
+	// get timeout
+	timeout := r.timeout()
+
+	// drop expired assemblies
+	var err error
+	for id, a := range r.assemblies {
+		if time.Since(a.created) > timeout {
+			delete(r.assemblies, id)
+			err = ErrChunkingTimeout
+		}
+	}
+
+	// forget completed and rejected messages
+	for id, finished := range r.finished {
+		if time.Since(finished) > timeout {
+			delete(r.finished, id)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,315 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/client/future"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/transport/flow"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func chunkedMessages(payload []byte, size int) []*packet.Message {
+	var msgs []*packet.Message
+	for _, chunk := range splitChunks(payload, size) {
+		msgs = append(msgs, &packet.Message{
+			Topic:   "test",
+			Payload: chunk,
+			QOS:     1,
+		})
+	}
+
+	return msgs
+}
+
+func TestReassembler(t *testing.T) {
+	r := newReassembler(&Chunking{})
+
+	msg := &packet.Message{Topic: "test", Payload: []byte("test")}
+	res, err := r.process(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, msg, res)
+
+	msgs := chunkedMessages([]byte("0123456789"), 3)
+	assert.Len(t, msgs, 5)
+
+	for _, i := range []int{4, 2, 2, 0, 3} {
+		res, err = r.process(msgs[i])
+		assert.NoError(t, err)
+		assert.Nil(t, res)
+	}
+
+	res, err = r.process(msgs[1])
+	assert.NoError(t, err)
+	assert.Equal(t, &packet.Message{
+		Topic:   "test",
+		Payload: []byte("0123456789"),
+		QOS:     1,
+	}, res)
+
+	res, err = r.process(msgs[1])
+	assert.NoError(t, err)
+	assert.Nil(t, res)
+	assert.Empty(t, r.assemblies)
+}
+
+func TestReassemblerEmpty(t *testing.T) {
+	r := newReassembler(&Chunking{})
+
+	msgs := chunkedMessages(nil, 3)
+	assert.Len(t, msgs, 1)
+
+	res, err := r.process(msgs[0])
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{}, res.Payload)
+}
+
+func TestReassemblerChecksumMismatch(t *testing.T) {
+	r := newReassembler(&Chunking{})
+
+	msgs := chunkedMessages([]byte("0123456789"), 5)
+	msgs[1].Payload[chunkHeaderLen] = 'x'
+
+	for _, msg := range msgs[:2] {
+		res, err := r.process(msg)
+		assert.NoError(t, err)
+		assert.Nil(t, res)
+	}
+
+	res, err := r.process(msgs[2])
+	assert.Equal(t, ErrChunkingChecksumMismatch, err)
+	assert.Nil(t, res)
+}
+
+func TestReassemblerTimeout(t *testing.T) {
+	r := newReassembler(&Chunking{
+		Timeout: 10 * time.Millisecond,
+	})
+
+	msgs := chunkedMessages([]byte("0123456789"), 5)
+
+	res, err := r.process(msgs[0])
+	assert.NoError(t, err)
+	assert.Nil(t, res)
+
+	time.Sleep(20 * time.Millisecond)
+
+	res, err = r.process(&packet.Message{Topic: "test", Payload: []byte("test")})
+	assert.Equal(t, ErrChunkingTimeout, err)
+	assert.NotNil(t, res)
+	assert.Empty(t, r.assemblies)
+}
+
+func TestReassemblerLimit(t *testing.T) {
+	r := newReassembler(&Chunking{
+		Limit: 2*chunkHeaderLen + 8,
+	})
+
+	msgs := chunkedMessages([]byte("0123456789"), 5)
+
+	res, err := r.process(msgs[1])
+	assert.NoError(t, err)
+	assert.Nil(t, res)
+
+	res, err = r.process(msgs[0])
+	assert.Equal(t, ErrChunkingLimitExceeded, err)
+	assert.Nil(t, res)
+
+	res, err = r.process(msgs[1])
+	assert.NoError(t, err)
+	assert.Nil(t, res)
+
+	res, err = r.process(msgs[2])
+	assert.NoError(t, err)
+	assert.Nil(t, res)
+	assert.Empty(t, r.assemblies)
+
+	msgs = chunkedMessages([]byte("0123456789"), 5)
+
+	res, err = r.process(msgs[1])
+	assert.NoError(t, err)
+	assert.Nil(t, res)
+
+	res, err = r.process(msgs[2])
+	assert.Equal(t, ErrChunkingLimitExceeded, err)
+	assert.Nil(t, res)
+
+	res, err = r.process(msgs[0])
+	assert.NoError(t, err)
+	assert.Nil(t, res)
+	assert.Empty(t, r.assemblies)
+}
+
+func TestReassemblerEmptyChunks(t *testing.T) {
+	r := newReassembler(&Chunking{
+		Limit: 4 * chunkHeaderLen,
+	})
+
+	var id chunkID
+	for i := 0; i < 5; i++ {
+		chunk := make([]byte, chunkHeaderLen)
+		encodeChunkHeader(chunk, chunkData, id, uint32(i))
+
+		res, err := r.process(&packet.Message{Topic: "test", Payload: chunk})
+		if i < 4 {
+			assert.NoError(t, err)
+		} else {
+			assert.Equal(t, ErrChunkingLimitExceeded, err)
+		}
+		assert.Nil(t, res)
+	}
+
+	assert.Empty(t, r.assemblies)
+}
+
+func TestReassemblerMaxAssemblies(t *testing.T) {
+	r := newReassembler(&Chunking{
+		MaxAssemblies: 1,
+	})
+
+	msgs1 := chunkedMessages([]byte("0123456789"), 5)
+	msgs2 := chunkedMessages([]byte("0123456789"), 5)
+
+	res, err := r.process(msgs1[0])
+	assert.NoError(t, err)
+	assert.Nil(t, res)
+
+	res, err = r.process(msgs2[0])
+	assert.Equal(t, ErrChunkingTooManyAssemblies, err)
+	assert.Nil(t, res)
+	assert.Len(t, r.assemblies, 1)
+
+	for _, msg := range msgs1[1:] {
+		res, err = r.process(msg)
+		assert.NoError(t, err)
+	}
+	assert.NotNil(t, res)
+
+	res, err = r.process(msgs2[1])
+	assert.NoError(t, err)
+	assert.Nil(t, res)
+	assert.Empty(t, r.assemblies)
+
+	msgs3 := chunkedMessages([]byte("0123456789"), 5)
+
+	res, err = r.process(msgs3[0])
+	assert.NoError(t, err)
+	assert.Nil(t, res)
+	assert.Len(t, r.assemblies, 1)
+}
+
+func TestServiceChunking(t *testing.T) {
+	msgs := chunkedMessages([]byte("0123456789"), 4)
+
+	var publishes []packet.Generic
+	var pubacks []packet.Generic
+	for i, msg := range msgs {
+		publish := packet.NewPublish()
+		publish.Message = *msg
+		publish.ID = packet.ID(i + 1)
+		publishes = append(publishes, publish)
+
+		puback := packet.NewPuback()
+		puback.ID = packet.ID(i + 1)
+		pubacks = append(pubacks, puback)
+	}
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Skip(publishes...).
+		Send(pubacks...).
+		Send(publishes[3], publishes[1], publishes[0], publishes[2]).
+		Receive(pubacks...).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	online := make(chan struct{})
+	message := make(chan struct{})
+
+	s := NewService()
+	s.Chunking = &Chunking{Size: 4}
+
+	s.OnlineCallback = func(bool) {
+		close(online)
+	}
+
+	s.MessageCallback = func(msg *packet.Message) error {
+		assert.Equal(t, "test", msg.Topic)
+		assert.Equal(t, []byte("0123456789"), msg.Payload)
+		close(message)
+		return nil
+	}
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	safeReceive(online)
+
+	assert.NoError(t, s.PublishChunked("test", []byte("0123456789")).Wait(1*time.Second))
+
+	safeReceive(message)
+
+	s.Stop(true)
+
+	safeReceive(done)
+}
+
+func TestServiceChunkingDisabled(t *testing.T) {
+	s := NewService()
+
+	var errs []error
+	s.ErrorCallback = func(err error) {
+		errs = append(errs, err)
+	}
+
+	assert.Equal(t, future.ErrCanceled, s.PublishChunked("test", []byte("test")).Wait(time.Second))
+	assert.Equal(t, []error{ErrChunkingDisabled}, errs)
+}
+
+func TestServiceChunkingTimeout(t *testing.T) {
+	msgs := chunkedMessages([]byte("0123456789"), 5)
+
+	publish := packet.NewPublish()
+	publish.Message = *msgs[0]
+	publish.ID = 1
+
+	puback := packet.NewPuback()
+	puback.ID = 1
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Send(publish).
+		Receive(puback).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	timeout := make(chan struct{})
+
+	s := NewService()
+	s.Chunking = &Chunking{Timeout: 20 * time.Millisecond}
+
+	s.MessageCallback = func(msg *packet.Message) error {
+		assert.Fail(t, "should not be called")
+		return nil
+	}
+
+	s.ErrorCallback = func(err error) {
+		assert.Equal(t, ErrChunkingTimeout, err)
+		close(timeout)
+	}
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	safeReceive(timeout)
+
+	s.Stop(true)
+
+	safeReceive(done)
+}
@@ -5,6 +5,7 @@ package client
 import (
 	"errors"
 	"fmt"
+	"math"
 	"net/url"
 	"sync"
 	"sync/atomic"
@@ -34,6 +35,23 @@ var ErrClientMissingID = errors.New("client missing id")
 // been reject by the broker.
 var ErrClientConnectionDenied = errors.New("client connection denied")
 
+// A ConnectionDeniedError is returned in the Callback if the connection has
+// been rejected by the broker. It carries the return code of the Connack packet
+// and matches ErrClientConnectionDenied when compared using errors.Is.
+type ConnectionDeniedError struct {
+	Code packet.ConnackCode
+}
+
+// Error implements the error interface.
+func (e *ConnectionDeniedError) Error() string {
+	return ErrClientConnectionDenied.Error() + ": " + e.Code.String()
+}
+
+// Is will return true if the target is ErrClientConnectionDenied.
+func (e *ConnectionDeniedError) Is(target error) bool {
+	return target == ErrClientConnectionDenied
+}
+
 // ErrClientMissingPong is returned in the Callback if the broker did not respond
 // in time to a Pingreq.
 var ErrClientMissingPong = errors.New("client missing pong")
@@ -59,6 +77,10 @@ type Callback func(msg *packet.Message, err error) error
 // A Logger is a function called by the client to log activity.
 type Logger func(msg string)
 
+// A PacketLogger is a function called by the client to log packets. The event
+// is either "Sent", "Received" or "Suppressed Duplicate".
+type PacketLogger func(event string, clientID string, pkt packet.Generic)
+
 const (
 	clientInitialized uint32 = iota
 	clientConnecting
@@ -70,8 +92,10 @@ const (
 
 // A Session is used to persist incoming and outgoing packets.
 type Session interface {
-	// NextID will return the next id for outgoing packets.
-	NextID() packet.ID
+	// NextID will return the next id for outgoing packets. Ids that are still
+	// in use by stored outgoing packets should be skipped and an error should
+	// be returned if no id is available.
+	NextID() (packet.ID, error)
 
 	// SavePacket will store a packet in the session. An eventual existing
 	// packet with the same id gets quietly overwritten.
@@ -104,8 +128,9 @@ type Session interface {
 type Client struct {
 	state uint32
 
-	config *Config
-	conn   transport.Conn
+	config    *Config
+	conn      transport.Conn
+	connMutex sync.Mutex
 
 	// The session used by the client to store unacknowledged packets.
 	Session Session
@@ -114,17 +139,47 @@ type Client struct {
 	// encountering an error while processing incoming packets.
 	Callback Callback
 
+	// Handler can be set to pass received messages to a pool of workers
+	// instead of calling the Callback. Received messages are only acknowledged
+	// once the handler calls Ack on the message. Errors are still reported
+	// using the Callback.
+	Handler Handler
+
+	// The callback to be called with errors that do not close the client. It
+	// is called for received messages with a payload that cannot be
+	// decompressed, which are acknowledged and dropped.
+	ErrorCallback ErrorCallback
+
+	// Workers sets the number of workers that call the Handler.
+	//
+	// Will default to 1.
+	Workers int
+
 	// The logger that is used to log low level information about packets
 	// that have been successfully sent and received and details about the
 	// automatic keep alive handler.
 	Logger Logger
 
+	// The packet logger that is used instead of the logger to log sent and
+	// received packets if set.
+	PacketLogger PacketLogger
+
 	clean bool
 
+	connect       *packet.Connect
+	negotiate     bool
 	keepAlive     time.Duration
 	tracker       *Tracker
 	futureStore   *future.Store
+	counters      *counters
 	connectFuture *future.Future
+	inflight      *inflightWindow
+	dedup         *dedupWindow
+	batches       sync.Map
+	requests      sync.Map
+	closing       chan struct{}
+	closeOnce     sync.Once
+	queues        []chan *Message
 
 	tomb   tomb.Tomb
 	mutex  sync.Mutex
@@ -137,6 +192,9 @@ func New() *Client {
 		state:       clientInitialized,
 		Session:     session.NewMemorySession(),
 		futureStore: future.NewStore(),
+		counters:    &counters{},
+		inflight:    newInflightWindow(0, InflightQueue),
+		closing:     make(chan struct{}),
 	}
 }
 
@@ -178,19 +236,19 @@ func (c *Client) Connect(config *Config) (ConnectFuture, error) {
 
 	// allocate and initialize tracker
 	c.keepAlive = keepAlive
-	c.tracker = NewTracker(keepAlive)
-
-	// dial broker (with custom dialer if present)
-	if config.Dialer != nil {
-		c.conn, err = config.Dialer.Dial(config.BrokerURL)
-		if err != nil {
-			return nil, err
-		}
+	if config.AdaptiveKeepAlive {
+		c.tracker = NewAdaptiveTracker(keepAlive)
 	} else {
-		c.conn, err = transport.Dial(config.BrokerURL)
-		if err != nil {
-			return nil, err
-		}
+		c.tracker = NewTracker(keepAlive)
+	}
+
+	// allocate inflight window
+	c.inflight = newInflightWindow(config.MaxInflight, config.InflightPolicy)
+
+	// dial broker
+	c.conn, err = c.dial()
+	if err != nil {
+		return nil, err
 	}
 
 	// set to connecting as from this point the client cannot be reused
@@ -210,12 +268,28 @@ func (c *Client) Connect(config *Config) (ConnectFuture, error) {
 		}
 	}
 
+	// prepare dedup window
+	if config.Dedup != nil {
+		c.dedup, err = newDedupWindow(config.Dedup, c.Session)
+		if err != nil {
+			return nil, c.cleanup(err, true, false)
+		}
+	}
+
 	// allocate packet
 	connect := packet.NewConnect()
 	connect.ClientID = config.ClientID
 	connect.KeepAlive = uint16(keepAlive.Seconds())
 	connect.CleanSession = config.CleanSession
 
+	// set protocol version
+	c.negotiate = config.ProtocolVersion == AutoProtocolVersion
+	if c.negotiate {
+		connect.Version = packet.Version311
+	} else if config.ProtocolVersion != 0 {
+		connect.Version = config.ProtocolVersion
+	}
+
 	// check for credentials
 	if urlParts.User != nil {
 		connect.Username = urlParts.User.Username()
@@ -225,6 +299,9 @@ func (c *Client) Connect(config *Config) (ConnectFuture, error) {
 	// set will
 	connect.Will = config.WillMessage
 
+	// save connect packet
+	c.connect = connect
+
 	// create new ConnectFuture
 	c.connectFuture = future.New()
 
@@ -234,6 +311,22 @@ func (c *Client) Connect(config *Config) (ConnectFuture, error) {
 		return nil, c.cleanup(err, false, false)
 	}
 
+	// start workers
+	if c.Handler != nil {
+		// get number of workers
+		workers := c.Workers
+		if workers <= 0 {
+			workers = 1
+		}
+
+		// prepare queues and start workers
+		c.queues = make([]chan *Message, workers)
+		for i := range c.queues {
+			c.queues[i] = make(chan *Message, 1)
+			c.tomb.Go(c.worker(c.queues[i]))
+		}
+	}
+
 	// start process routine
 	c.tomb.Go(c.processor)
 
@@ -269,13 +362,10 @@ func (c *Client) PublishMessage(msg *packet.Message) (GenericFuture, error) {
 		return nil, ErrClientNotConnected
 	}
 
-	// allocate publish packet
-	publish := packet.NewPublish()
-	publish.Message = *msg
-
-	// set packet id
-	if msg.QOS > 0 {
-		publish.ID = c.Session.NextID()
+	// prepare publish packet
+	publish, err := c.preparePublish(msg)
+	if err != nil {
+		return nil, err
 	}
 
 	// create future
@@ -285,21 +375,26 @@ func (c *Client) PublishMessage(msg *packet.Message) (GenericFuture, error) {
 	c.futureStore.Put(publish.ID, publishFuture)
 
 	// store packet if at least qos 1
-	if msg.QOS > 0 {
-		err := c.Session.SavePacket(session.Outgoing, publish)
+	if publish.Message.QOS > 0 {
+		err = c.Session.SavePacket(session.Outgoing, publish)
 		if err != nil {
 			return nil, c.cleanup(err, true, false)
 		}
+
+		// add to inflight window and return if the publish has been queued
+		if !c.inflight.add(publish) {
+			return publishFuture, nil
+		}
 	}
 
 	// send packet
-	err := c.send(publish, true)
+	err = c.send(publish, true)
 	if err != nil {
 		return nil, c.cleanup(err, false, false)
 	}
 
 	// complete and remove qos 0 future
-	if msg.QOS == 0 {
+	if publish.Message.QOS == 0 {
 		publishFuture.Complete()
 		c.futureStore.Delete(publish.ID)
 	}
@@ -307,6 +402,43 @@ func (c *Client) PublishMessage(msg *packet.Message) (GenericFuture, error) {
 	return publishFuture, nil
 }
 
+// prepares a publish packet for the message
+func (c *Client) preparePublish(msg *packet.Message) (*packet.Publish, error) {
+	// compress payload
+	if c.config.Compression != nil {
+		var err error
+		msg, err = c.config.Compression.Compress(msg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// reserve space in the inflight window
+	if msg.QOS > 0 {
+		err := c.inflight.reserve(c.tomb.Dying(), c.closing)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// allocate publish packet
+	publish := packet.NewPublish()
+	publish.Message = *msg
+
+	// set packet id
+	if msg.QOS > 0 {
+		id, err := c.nextID()
+		if err != nil {
+			c.inflight.unreserve()
+			return nil, err
+		}
+
+		publish.ID = id
+	}
+
+	return publish, nil
+}
+
 // Subscribe will send a Subscribe packet containing one topic to subscribe. It
 // will return a SubscribeFuture that gets completed once a Suback packet has
 // been received.
@@ -328,9 +460,15 @@ func (c *Client) SubscribeMultiple(subscriptions []packet.Subscription) (Subscri
 		return nil, ErrClientNotConnected
 	}
 
+	// get packet id
+	id, err := c.nextID()
+	if err != nil {
+		return nil, err
+	}
+
 	// allocate subscribe packet
 	subscribe := packet.NewSubscribe()
-	subscribe.ID = c.Session.NextID()
+	subscribe.ID = id
 	subscribe.Subscriptions = subscriptions
 
 	// create future
@@ -339,8 +477,11 @@ func (c *Client) SubscribeMultiple(subscriptions []packet.Subscription) (Subscri
 	// store future
 	c.futureStore.Put(subscribe.ID, subFuture)
 
+	// track pending id
+	c.requests.Store(subscribe.ID, struct{}{})
+
 	// send packet
-	err := c.send(subscribe, true)
+	err = c.send(subscribe, true)
 	if err != nil {
 		return nil, c.cleanup(err, false, false)
 	}
@@ -370,10 +511,16 @@ func (c *Client) UnsubscribeMultiple(topics []string) (GenericFuture, error) {
 		return nil, ErrClientNotConnected
 	}
 
+	// get packet id
+	id, err := c.nextID()
+	if err != nil {
+		return nil, err
+	}
+
 	// allocate unsubscribe packet
 	unsubscribe := packet.NewUnsubscribe()
 	unsubscribe.Topics = topics
-	unsubscribe.ID = c.Session.NextID()
+	unsubscribe.ID = id
 
 	// create future
 	unsubscribeFuture := future.New()
@@ -381,8 +528,11 @@ func (c *Client) UnsubscribeMultiple(topics []string) (GenericFuture, error) {
 	// store future
 	c.futureStore.Put(unsubscribe.ID, unsubscribeFuture)
 
+	// track pending id
+	c.requests.Store(unsubscribe.ID, struct{}{})
+
 	// send packet
-	err := c.send(unsubscribe, true)
+	err = c.send(unsubscribe, true)
 	if err != nil {
 		return nil, c.cleanup(err, false, false)
 	}
@@ -396,6 +546,11 @@ func (c *Client) UnsubscribeMultiple(topics []string) (GenericFuture, error) {
 // for all queued futures to complete or cancel. If no timeout is specified it
 // will not wait at all.
 func (c *Client) Disconnect(timeout ...time.Duration) error {
+	// wake up blocked publishes
+	if atomic.LoadUint32(&c.state) == clientConnected {
+		c.close()
+	}
+
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
@@ -421,6 +576,11 @@ func (c *Client) Disconnect(timeout ...time.Duration) error {
 // Close closes the client immediately without sending a Disconnect packet and
 // waiting for outgoing transmissions to finish.
 func (c *Client) Close() error {
+	// wake up blocked publishes
+	if atomic.LoadUint32(&c.state) >= clientConnecting {
+		c.close()
+	}
+
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
@@ -438,8 +598,9 @@ func (c *Client) Close() error {
 func (c *Client) processor() error {
 	first := true
 
-	// start keep alive if greater than zero
-	if c.keepAlive > 0 {
+	// start keep alive if greater than zero and the protocol version is not
+	// negotiated
+	if c.keepAlive > 0 && !c.negotiate {
 		c.tomb.Go(c.pinger)
 	}
 
@@ -447,6 +608,17 @@ func (c *Client) processor() error {
 		// get next packet from connection
 		pkt, err := c.conn.Receive()
 		if err != nil {
+			// fall back to an older protocol version if the connection has
+			// been closed before receiving the connack
+			if first {
+				ok, fallbackErr := c.fallback()
+				if fallbackErr != nil {
+					return c.die(fallbackErr, true, false)
+				} else if ok {
+					continue
+				}
+			}
+
 			// if we are disconnecting we can ignore the error
 			if atomic.LoadUint32(&c.state) >= clientDisconnecting {
 				return nil
@@ -456,10 +628,11 @@ func (c *Client) processor() error {
 			return c.die(err, false, false)
 		}
 
+		// count received packet
+		c.counters.received(pkt)
+
 		// log received message
-		if c.Logger != nil {
-			c.Logger(fmt.Sprintf("Received: %s", pkt.String()))
-		}
+		c.logPacket("Received", pkt)
 
 		if first {
 			// get connack
@@ -468,10 +641,26 @@ func (c *Client) processor() error {
 				return c.die(ErrClientExpectedConnack, true, false)
 			}
 
+			// fall back to an older protocol version if rejected
+			if connack.ReturnCode == packet.InvalidProtocolVersion {
+				ok, err := c.fallback()
+				if err != nil {
+					return c.die(err, true, false)
+				} else if ok {
+					continue
+				}
+			}
+
 			// process connack
 			err = c.processConnack(connack)
 			first = false
 
+			// start keep alive if greater than zero and the protocol version
+			// has been negotiated
+			if c.keepAlive > 0 && c.negotiate && atomic.LoadUint32(&c.state) == clientConnected {
+				c.tomb.Go(c.pinger)
+			}
+
 			// move on
 			continue
 		}
@@ -516,10 +705,11 @@ func (c *Client) processConnack(connack *packet.Connack) error {
 	// fill future
 	c.connectFuture.Data.Store(sessionPresentKey, connack.SessionPresent)
 	c.connectFuture.Data.Store(returnCodeKey, connack.ReturnCode)
+	c.connectFuture.Data.Store(versionKey, c.connect.Version)
 
 	// return connection denied error and close connection if not accepted
 	if connack.ReturnCode != packet.ConnectionAccepted {
-		err := c.die(ErrClientConnectionDenied, true, false)
+		err := c.die(&ConnectionDeniedError{Code: connack.ReturnCode}, true, false)
 		c.connectFuture.Cancel()
 		return err
 	}
@@ -545,6 +735,13 @@ func (c *Client) processConnack(connack *packet.Connack) error {
 			publish.Dup = true
 		}
 
+		// add publish and pubrel packets to the inflight window
+		switch pkt.Type() {
+		case packet.PUBLISH, packet.PUBREL:
+			id, _ := packet.GetID(pkt)
+			c.inflight.mark(id)
+		}
+
 		// resend packet
 		err = c.send(pkt, true)
 		if err != nil {
@@ -563,6 +760,9 @@ func (c *Client) processSuback(suback *packet.Suback) error {
 		return err
 	}
 
+	// release id
+	c.requests.Delete(suback.ID)
+
 	// get future
 	subscribeFuture := c.futureStore.Get(suback.ID)
 	if subscribeFuture == nil {
@@ -572,6 +772,9 @@ func (c *Client) processSuback(suback *packet.Suback) error {
 	// remove future from store
 	c.futureStore.Delete(suback.ID)
 
+	// store return codes
+	subscribeFuture.Data.Store(returnCodesKey, suback.ReturnCodes)
+
 	// validate subscriptions if requested
 	if c.config.ValidateSubs {
 		for _, code := range suback.ReturnCodes {
@@ -583,7 +786,6 @@ func (c *Client) processSuback(suback *packet.Suback) error {
 	}
 
 	// complete future
-	subscribeFuture.Data.Store(returnCodesKey, suback.ReturnCodes)
 	subscribeFuture.Complete()
 
 	return nil
@@ -597,6 +799,9 @@ func (c *Client) processUnsuback(unsuback *packet.Unsuback) error {
 		return err
 	}
 
+	// release id
+	c.requests.Delete(unsuback.ID)
+
 	// get future
 	unsubscribeFuture := c.futureStore.Get(unsuback.ID)
 	if unsubscribeFuture == nil {
@@ -614,6 +819,57 @@ func (c *Client) processUnsuback(unsuback *packet.Unsuback) error {
 
 // handle an incoming Publish packet
 func (c *Client) processPublish(publish *packet.Publish) error {
+	// decompress payload
+	if c.config.Compression != nil {
+		msg, err := c.config.Compression.Decompress(&publish.Message)
+		if err != nil {
+			// report error
+			if c.ErrorCallback != nil {
+				c.ErrorCallback(err)
+			} else if c.Logger != nil {
+				c.Logger(fmt.Sprintf("Decompress Error: %s", err.Error()))
+			}
+
+			return c.drop(publish)
+		}
+
+		publish.Message = *msg
+	}
+
+	// suppress duplicate qos 1 messages
+	var key string
+	if c.dedup != nil && publish.Message.QOS == 1 {
+		var duplicate bool
+		var err error
+		key, duplicate, err = c.dedup.key(publish)
+		if err != nil {
+			return c.die(err, true, false)
+		}
+
+		// acknowledge duplicate
+		if duplicate {
+			// log suppressed message
+			c.logPacket("Suppressed Duplicate", publish)
+
+			// prepare puback packet
+			puback := packet.NewPuback()
+			puback.ID = publish.ID
+
+			// acknowledge qos 1 publish
+			err = c.send(puback, true)
+			if err != nil {
+				return c.die(err, false, false)
+			}
+
+			return nil
+		}
+	}
+
+	// dispatch unacknowledged and directly acknowledged messages
+	if c.Handler != nil && publish.Message.QOS <= 1 {
+		return c.dispatch(publish, key)
+	}
+
 	// call callback for unacknowledged and directly acknowledged messages
 	if publish.Message.QOS <= 1 {
 		if c.Callback != nil {
@@ -624,6 +880,14 @@ func (c *Client) processPublish(publish *packet.Publish) error {
 		}
 	}
 
+	// remember key
+	if key != "" {
+		err := c.dedup.remember(key)
+		if err != nil {
+			return c.die(err, true, false)
+		}
+	}
+
 	// handle qos 1 flow
 	if publish.Message.QOS == 1 {
 		// prepare puback packet
@@ -659,6 +923,38 @@ func (c *Client) processPublish(publish *packet.Publish) error {
 	return nil
 }
 
+// acknowledge an incoming Publish packet without delivering the message
+func (c *Client) drop(publish *packet.Publish) error {
+	// prepare acknowledgment
+	var ack packet.Generic
+	switch publish.Message.QOS {
+	case 1:
+		puback := packet.NewPuback()
+		puback.ID = publish.ID
+		ack = puback
+	case 2:
+		pubrec := packet.NewPubrec()
+		pubrec.ID = publish.ID
+		ack = pubrec
+
+		// store pubrec to complete the flow once released
+		err := c.Session.SavePacket(session.Incoming, pubrec)
+		if err != nil {
+			return c.die(err, true, false)
+		}
+	default:
+		return nil
+	}
+
+	// send acknowledgment
+	err := c.send(ack, true)
+	if err != nil {
+		return c.die(err, false, false)
+	}
+
+	return nil
+}
+
 // handle an incoming Puback or Pubcomp packet
 func (c *Client) processPubackAndPubcomp(id packet.ID) error {
 	// remove packet from store
@@ -667,6 +963,21 @@ func (c *Client) processPubackAndPubcomp(id packet.ID) error {
 		return err
 	}
 
+	// send next queued publish
+	next := c.inflight.release(id)
+	if next != nil {
+		err = c.send(next, true)
+		if err != nil {
+			return c.die(err, false, false)
+		}
+	}
+
+	// acknowledge batched publish
+	if batch, ok := c.batches.LoadAndDelete(id); ok {
+		batch.(*publishBatch).ack(id)
+		return nil
+	}
+
 	// get future
 	publishFuture := c.futureStore.Get(id)
 	if publishFuture == nil {
@@ -711,12 +1022,22 @@ func (c *Client) processPubrel(id packet.ID) error {
 		return c.die(err, true, false)
 	}
 
+	// complete the flow of dropped messages
+	if _, ok := pkt.(*packet.Pubrec); ok {
+		return c.complete(id)
+	}
+
 	// get packet from store
 	publish, ok := pkt.(*packet.Publish)
 	if !ok {
 		return nil // ignore a wrongly sent Pubrel packet
 	}
 
+	// dispatch message
+	if c.Handler != nil {
+		return c.dispatch(publish, "")
+	}
+
 	// call callback
 	if c.Callback != nil {
 		err = c.Callback(&publish.Message, nil)
@@ -725,12 +1046,17 @@ func (c *Client) processPubrel(id packet.ID) error {
 		}
 	}
 
+	return c.complete(id)
+}
+
+// acknowledge a released Publish packet and remove it from the session
+func (c *Client) complete(id packet.ID) error {
 	// prepare pubcomp packet
 	pubcomp := packet.NewPubcomp()
-	pubcomp.ID = publish.ID
+	pubcomp.ID = id
 
 	// acknowledge Publish packet
-	err = c.send(pubcomp, true)
+	err := c.send(pubcomp, true)
 	if err != nil {
 		return c.die(err, false, false)
 	}
@@ -749,25 +1075,35 @@ func (c *Client) processPubrel(id packet.ID) error {
 // manages the sending of ping packets to keep the connection alive
 func (c *Client) pinger() error {
 	for {
-		// get current window
-		window := c.tracker.Window()
+		var window time.Duration
+
+		// check if a ping is pending
+		if c.tracker.Pending() {
+			// get pong window
+			window = c.tracker.PongWindow()
 
-		// check if ping is due
-		if window < 0 {
-			// check if a pong has already been sent
-			if c.tracker.Pending() {
+			// check if the pong is overdue
+			if window < 0 {
 				return c.die(ErrClientMissingPong, true, false)
 			}
+		} else {
+			// get current window
+			window = c.tracker.Window()
 
-			// send pingreq packet
-			err := c.send(packet.NewPingreq(), true)
-			if err != nil {
-				return c.die(err, false, false)
+			// check if ping is due
+			if window < 0 {
+				// send pingreq packet
+				err := c.send(packet.NewPingreq(), true)
+				if err != nil {
+					return c.die(err, false, false)
+				}
+
+				// save ping attempt
+				c.tracker.Ping()
+
+				continue
 			}
 
-			// save ping attempt
-			c.tracker.Ping()
-		} else {
 			// log keep alive delay
 			if c.Logger != nil {
 				c.Logger(fmt.Sprintf("Delay KeepAlive by %s", window.String()))
@@ -785,6 +1121,94 @@ func (c *Client) pinger() error {
 
 /* helpers */
 
+// dials the broker (with custom dialer if present)
+func (c *Client) dial() (transport.Conn, error) {
+	if c.config.Dialer != nil {
+		return c.config.Dialer.Dial(c.config.BrokerURL)
+	}
+
+	return transport.Dial(c.config.BrokerURL)
+}
+
+// reconnects and resends the connect packet using the next older protocol
+// version if the version is negotiated and returns whether it did
+func (c *Client) fallback() (bool, error) {
+	// check if an older version is available
+	if !c.negotiate || c.connect.Version != packet.Version311 {
+		return false, nil
+	}
+
+	// log fallback
+	if c.Logger != nil {
+		c.Logger(fmt.Sprintf("Fallback to Protocol Version %d", packet.Version31))
+	}
+
+	// dial broker
+	conn, err := c.dial()
+	if err != nil {
+		return false, err
+	}
+
+	// replace connection if still connecting
+	c.connMutex.Lock()
+	if atomic.LoadUint32(&c.state) != clientConnecting {
+		c.connMutex.Unlock()
+		_ = conn.Close()
+		return false, nil
+	}
+	oldConn := c.conn
+	c.conn = conn
+	c.connMutex.Unlock()
+
+	// close old connection
+	_ = oldConn.Close()
+
+	// resend connect packet
+	c.connect.Version = packet.Version31
+	err = c.send(c.connect, false)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// returns the next id that is neither used by a stored packet nor by a pending
+// subscribe or unsubscribe packet of the current connection
+func (c *Client) nextID() (packet.ID, error) {
+	// try all ids once
+	for i := 0; i < math.MaxUint16; i++ {
+		// get next id
+		id, err := c.Session.NextID()
+		if err != nil {
+			return 0, err
+		}
+
+		// check pending subscribe and unsubscribe packets
+		if _, ok := c.requests.Load(id); !ok {
+			return id, nil
+		}
+	}
+
+	return 0, session.ErrNoFreeID
+}
+
+// signals that the client is closing
+func (c *Client) close() {
+	c.closeOnce.Do(func() {
+		close(c.closing)
+	})
+}
+
+// logs the packet using the packet logger or logger
+func (c *Client) logPacket(event string, pkt packet.Generic) {
+	if c.PacketLogger != nil {
+		c.PacketLogger(event, c.config.ClientID, pkt)
+	} else if c.Logger != nil {
+		c.Logger(fmt.Sprintf("%s: %s", event, pkt.String()))
+	}
+}
+
 // sends packet and updates lastSend
 func (c *Client) send(pkt packet.Generic, async bool) error {
 	// reset keep alive tracker
@@ -796,10 +1220,11 @@ func (c *Client) send(pkt packet.Generic, async bool) error {
 		return err
 	}
 
+	// count sent packet
+	c.counters.sent(pkt)
+
 	// log sent packet
-	if c.Logger != nil {
-		c.Logger(fmt.Sprintf("Sent: %s", pkt.String()))
-	}
+	c.logPacket("Sent", pkt)
 
 	return nil
 }
@@ -811,12 +1236,15 @@ func (c *Client) cleanup(err error, doClose bool, possiblyClosed bool) error {
 		c.connectFuture.Cancel()
 	}
 
-	// set state
+	// set state and get connection
+	c.connMutex.Lock()
 	atomic.StoreUint32(&c.state, clientDisconnected)
+	conn := c.conn
+	c.connMutex.Unlock()
 
 	// ensure that the connection gets closed
 	if doClose {
-		connErr := c.conn.Close()
+		connErr := conn.Close()
 		if connErr != nil && err == nil && !possiblyClosed {
 			err = connErr
 		}
@@ -833,6 +1261,13 @@ func (c *Client) cleanup(err error, doClose bool, possiblyClosed bool) error {
 	// cancel all futures
 	c.futureStore.Clear()
 
+	// fail all batched publishes
+	c.batches.Range(func(id, batch interface{}) bool {
+		c.batches.Delete(id)
+		batch.(*publishBatch).fail(id.(packet.ID))
+		return true
+	})
+
 	return err
 }
 
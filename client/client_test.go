@@ -211,7 +211,8 @@ func TestClientConnectionDenied(t *testing.T) {
 	c := New()
 	c.Callback = func(msg *packet.Message, err error) error {
 		assert.Nil(t, msg)
-		assert.Equal(t, ErrClientConnectionDenied, err)
+		assert.True(t, errors.Is(err, ErrClientConnectionDenied))
+		assert.Equal(t, &ConnectionDeniedError{Code: packet.NotAuthorized}, err)
 		close(wait)
 		return nil
 	}
@@ -226,6 +227,145 @@ func TestClientConnectionDenied(t *testing.T) {
 	safeReceive(wait)
 }
 
+func TestClientProtocolVersionAuto(t *testing.T) {
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.ProtocolVersion = AutoProtocolVersion
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+	assert.Equal(t, packet.Version311, connectFuture.Version())
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientProtocolVersionFallback(t *testing.T) {
+	connack := connackPacket()
+	connack.ReturnCode = packet.InvalidProtocolVersion
+
+	connect := connectPacket()
+	connect.Version = packet.Version31
+
+	broker1 := flow.New().
+		Receive(connectPacket()).
+		Send(connack).
+		End()
+
+	broker2 := flow.New().
+		Receive(connect).
+		Send(connackPacket()).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker1, broker2)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.ProtocolVersion = AutoProtocolVersion
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+	assert.Equal(t, packet.ConnectionAccepted, connectFuture.ReturnCode())
+	assert.Equal(t, packet.Version31, connectFuture.Version())
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientProtocolVersionFallbackClose(t *testing.T) {
+	connect := connectPacket()
+	connect.Version = packet.Version31
+
+	broker1 := flow.New().
+		Receive(connectPacket()).
+		Close()
+
+	broker2 := flow.New().
+		Receive(connect).
+		Send(connackPacket()).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker1, broker2)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.ProtocolVersion = AutoProtocolVersion
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+	assert.Equal(t, packet.Version31, connectFuture.Version())
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientProtocolVersionFallbackDenied(t *testing.T) {
+	connack := connackPacket()
+	connack.ReturnCode = packet.InvalidProtocolVersion
+
+	connect := connectPacket()
+	connect.Version = packet.Version31
+
+	broker1 := flow.New().
+		Receive(connectPacket()).
+		Send(connack).
+		End()
+
+	broker2 := flow.New().
+		Receive(connect).
+		Send(connack).
+		Close()
+
+	done, port := fakeBroker(t, broker1, broker2)
+
+	wait := make(chan struct{})
+
+	c := New()
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.Nil(t, msg)
+		assert.Equal(t, &ConnectionDeniedError{Code: packet.InvalidProtocolVersion}, err)
+		close(wait)
+		return nil
+	}
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.ProtocolVersion = AutoProtocolVersion
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.Error(t, connectFuture.Wait(1*time.Second))
+	assert.Equal(t, packet.InvalidProtocolVersion, connectFuture.ReturnCode())
+	assert.Equal(t, packet.Version31, connectFuture.Version())
+
+	safeReceive(done)
+	safeReceive(wait)
+}
+
 func TestClientExpectedConnack(t *testing.T) {
 	broker := flow.New().
 		Receive(connectPacket()).
@@ -342,6 +482,54 @@ func TestClientKeepAliveTimeout(t *testing.T) {
 	safeReceive(done)
 }
 
+func TestClientAdaptiveKeepAliveTimeout(t *testing.T) {
+	connect := connectPacket()
+	connect.KeepAlive = 0
+
+	pingreq := packet.NewPingreq()
+	pingresp := packet.NewPingresp()
+
+	broker := flow.New().
+		Receive(connect).
+		Send(connackPacket()).
+		Receive(pingreq).
+		Send(pingresp).
+		Receive(pingreq).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	wait := make(chan struct{})
+
+	var lastPing atomic.Value
+
+	c := New()
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.Nil(t, msg)
+		assert.Equal(t, ErrClientMissingPong, err)
+		assert.True(t, time.Since(lastPing.Load().(time.Time)) < 300*time.Millisecond)
+		close(wait)
+		return nil
+	}
+
+	c.Logger = func(message string) {
+		if strings.Contains(message, "Sent: <Pingreq") {
+			lastPing.Store(time.Now())
+		}
+	}
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.KeepAlive = "400ms"
+	config.AdaptiveKeepAlive = true
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	safeReceive(wait)
+	safeReceive(done)
+}
+
 func TestClientPublishSubscribeQOS0(t *testing.T) {
 	subscribe := packet.NewSubscribe()
 	subscribe.Subscriptions = []packet.Subscription{{Topic: "test"}}
@@ -810,6 +998,128 @@ func TestClientSessionResumption(t *testing.T) {
 	assert.Equal(t, 0, len(pkts))
 }
 
+func TestClientSessionInFlightID(t *testing.T) {
+	connect := connectPacket()
+	connect.ClientID = "test"
+	connect.CleanSession = false
+
+	publish1 := packet.NewPublish()
+	publish1.Message.Topic = "test"
+	publish1.Message.Payload = []byte("test")
+	publish1.Message.QOS = 1
+	publish1.ID = 1
+	publish1.Dup = true
+
+	publish2 := packet.NewPublish()
+	publish2.Message.Topic = "test"
+	publish2.Message.Payload = []byte("test")
+	publish2.Message.QOS = 1
+	publish2.ID = 2
+
+	puback1 := packet.NewPuback()
+	puback1.ID = 1
+
+	puback2 := packet.NewPuback()
+	puback2.ID = 2
+
+	broker := flow.New().
+		Receive(connect).
+		Send(connackPacket()).
+		Receive(publish1).
+		Receive(publish2).
+		Send(puback1).
+		Send(puback2).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+	c.Session.SavePacket(session.Outgoing, publish1)
+	c.Callback = errorCallback(t)
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.ClientID = "test"
+	config.CleanSession = false
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	publishFuture, err := c.Publish("test", []byte("test"), 1, false)
+	assert.NoError(t, err)
+	assert.NoError(t, publishFuture.Wait(1*time.Second))
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+
+	pkts, err := c.Session.AllPackets(session.Outgoing)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(pkts))
+}
+
+func TestClientPendingSubscribeID(t *testing.T) {
+	subscribe := packet.NewSubscribe()
+	subscribe.Subscriptions = []packet.Subscription{{Topic: "test"}}
+	subscribe.ID = 65535
+
+	publish := packet.NewPublish()
+	publish.Message.Topic = "test"
+	publish.Message.Payload = []byte("test")
+	publish.Message.QOS = 1
+	publish.ID = 1
+
+	suback := packet.NewSuback()
+	suback.ReturnCodes = []packet.QOS{0}
+	suback.ID = 65535
+
+	puback := packet.NewPuback()
+	puback.ID = 1
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe).
+		Receive(publish).
+		Send(suback).
+		Send(puback).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	sess := session.NewMemorySession()
+
+	c := New()
+	c.Session = sess
+	c.Callback = errorCallback(t)
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	sess.Counter = session.NewIDCounterWithNext(65535)
+
+	subscribeFuture, err := c.Subscribe("test", 0)
+	assert.NoError(t, err)
+
+	// simulate wrap around
+	sess.Counter = session.NewIDCounterWithNext(65535)
+
+	publishFuture, err := c.Publish("test", []byte("test"), 1, false)
+	assert.NoError(t, err)
+
+	assert.NoError(t, subscribeFuture.Wait(1*time.Second))
+	assert.NoError(t, publishFuture.Wait(1*time.Second))
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
 func TestClientUnexpectedClose(t *testing.T) {
 	broker := flow.New().
 		Receive(connectPacket()).
@@ -929,6 +1239,76 @@ func TestClientErrorCallback(t *testing.T) {
 	safeReceive(done)
 }
 
+func TestClientDecompressionError(t *testing.T) {
+	publish1 := packet.NewPublish()
+	publish1.Message.Topic = "test"
+	publish1.Message.Payload = []byte("invalid")
+	publish1.Message.QOS = 1
+	publish1.ID = 1
+
+	puback := packet.NewPuback()
+	puback.ID = 1
+
+	publish2 := packet.NewPublish()
+	publish2.Message.Topic = "test"
+	publish2.Message.Payload = []byte("invalid")
+	publish2.Message.QOS = 2
+	publish2.ID = 2
+
+	pubrec := packet.NewPubrec()
+	pubrec.ID = 2
+
+	pubrel := packet.NewPubrel()
+	pubrel.ID = 2
+
+	pubcomp := packet.NewPubcomp()
+	pubcomp.ID = 2
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Send(publish1).
+		Receive(puback).
+		Send(publish2).
+		Receive(pubrec).
+		Send(pubrel).
+		Receive(pubcomp).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	var errs []error
+
+	c := New()
+	c.Callback = errorCallback(t)
+	c.ErrorCallback = func(err error) {
+		errs = append(errs, err)
+	}
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.Compression = &packet.Compression{
+		Compressor: packet.GzipCompressor{},
+	}
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	time.Sleep(50 * time.Millisecond)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+
+	assert.Len(t, errs, 2)
+
+	in, err := c.Session.AllPackets(session.Incoming)
+	assert.NoError(t, err)
+	assert.Empty(t, in)
+}
+
 func TestClientLogger(t *testing.T) {
 	subscribe := packet.NewSubscribe()
 	subscribe.Subscriptions = []packet.Subscription{{Topic: "test"}}
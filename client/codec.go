@@ -0,0 +1,177 @@
+package client
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"strings"
+
+	"github.com/256dpi/gomqtt/client/future"
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// A Codec encodes and decodes message payloads.
+type Codec interface {
+	// ContentType should return the content type of the codec (e.g. "json").
+	ContentType() string
+
+	// Encode should encode the supplied value.
+	Encode(value interface{}) ([]byte, error)
+
+	// Decode should decode the supplied payload into the value.
+	Decode(payload []byte, value interface{}) error
+}
+
+// JSONCodec encodes and decodes payloads using the encoding/json package.
+type JSONCodec struct{}
+
+// ContentType will return "json".
+func (JSONCodec) ContentType() string {
+	return "json"
+}
+
+// Encode will encode the supplied value as JSON.
+func (JSONCodec) Encode(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+// Decode will decode the supplied JSON payload into the value.
+func (JSONCodec) Decode(payload []byte, value interface{}) error {
+	return json.Unmarshal(payload, value)
+}
+
+// GobCodec encodes and decodes payloads using the encoding/gob package.
+type GobCodec struct{}
+
+// ContentType will return "gob".
+func (GobCodec) ContentType() string {
+	return "gob"
+}
+
+// Encode will encode the supplied value as gob.
+func (GobCodec) Encode(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decode will decode the supplied gob payload into the value.
+func (GobCodec) Decode(payload []byte, value interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(payload)).Decode(value)
+}
+
+var builtinCodecs = []Codec{JSONCodec{}, GobCodec{}}
+
+// ContentType returns the content type of a topic that follows the convention
+// of appending the content type to the last level (e.g. "sensors/temp.json").
+// An empty string is returned if the topic has no content type.
+func ContentType(topic string) string {
+	// get last level
+	level := topic[strings.LastIndexByte(topic, '/')+1:]
+
+	// get extension
+	i := strings.LastIndexByte(level, '.')
+	if i < 0 {
+		return ""
+	}
+
+	return level[i+1:]
+}
+
+// a value handler is registered for a subscription
+type valueHandler struct {
+	fn func(msg *packet.Message) error
+}
+
+// SubscribeValue will subscribe the service to the specified topic and call
+// the supplied function with decoded values of received messages that match
+// the subscription. Messages that cannot be decoded are acknowledged and the
+// error is reported using the ErrorCallback. If the function returns an error
+// the message is not acknowledged and the underlying client closes.
+func SubscribeValue[T any](s *Service, topic string, qos packet.QOS, fn func(topic string, value T) error) SubscribeFuture {
+	// prepare handler
+	handler := &valueHandler{
+		fn: func(msg *packet.Message) error {
+			// decode value
+			var value T
+			err := s.codec(msg.Topic).Decode(msg.Payload, &value)
+			if err != nil {
+				s.err("Codec", err)
+				return nil
+			}
+
+			return fn(msg.Topic, value)
+		},
+	}
+
+	// save handler
+	s.mutex.Lock()
+	s.handlers.Set(topic, handler)
+	s.mutex.Unlock()
+
+	return s.Subscribe(topic, qos)
+}
+
+// PublishValue will encode the supplied value and publish it. The codec is
+// selected using the content type of the topic. If the value cannot be encoded
+// the error is reported using the ErrorCallback and the returned future is
+// canceled.
+func (s *Service) PublishValue(topic string, value interface{}, qos packet.QOS, retain bool) GenericFuture {
+	// encode value
+	payload, err := s.codec(topic).Encode(value)
+	if err != nil {
+		s.err("Codec", err)
+
+		// cancel future
+		f := future.New()
+		f.Cancel()
+
+		return f
+	}
+
+	return s.Publish(topic, payload, qos, retain)
+}
+
+// returns the codec for the specified topic
+func (s *Service) codec(topic string) Codec {
+	// detect content type
+	contentType := ContentType(topic)
+	if contentType != "" {
+		// check registered codecs
+		for _, codec := range s.Codecs {
+			if codec.ContentType() == contentType {
+				return codec
+			}
+		}
+
+		// check builtin codecs
+		for _, codec := range builtinCodecs {
+			if codec.ContentType() == contentType {
+				return codec
+			}
+		}
+	}
+
+	// use default codec
+	if s.Codec != nil {
+		return s.Codec
+	}
+
+	return JSONCodec{}
+}
+
+// calls all value handlers that match the message
+func (s *Service) handle(msg *packet.Message) error {
+	for _, handler := range s.handlers.Match(msg.Topic) {
+		err := handler.fn(msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/transport/flow"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type codecValue struct {
+	Name  string
+	Count int
+}
+
+func TestContentType(t *testing.T) {
+	assert.Equal(t, "json", ContentType("foo/bar.json"))
+	assert.Equal(t, "gob", ContentType("bar.gob"))
+	assert.Equal(t, "", ContentType("foo.json/bar"))
+	assert.Equal(t, "", ContentType("foo/bar"))
+}
+
+func TestCodecs(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec{}, GobCodec{}} {
+		payload, err := codec.Encode(codecValue{Name: "foo", Count: 42})
+		assert.NoError(t, err)
+
+		var value codecValue
+		err = codec.Decode(payload, &value)
+		assert.NoError(t, err)
+		assert.Equal(t, codecValue{Name: "foo", Count: 42}, value)
+	}
+}
+
+func TestServicePublishSubscribeValue(t *testing.T) {
+	subscribe := packet.NewSubscribe()
+	subscribe.Subscriptions = []packet.Subscription{{Topic: "test/+"}}
+	subscribe.ID = 1
+
+	suback := packet.NewSuback()
+	suback.ReturnCodes = []packet.QOS{0}
+	suback.ID = 1
+
+	publish1 := packet.NewPublish()
+	publish1.Message.Topic = "test/foo"
+	publish1.Message.Payload = []byte(`{"Name":"foo","Count":1}`)
+
+	payload, err := GobCodec{}.Encode(codecValue{Name: "bar", Count: 2})
+	assert.NoError(t, err)
+
+	publish2 := packet.NewPublish()
+	publish2.Message.Topic = "test/bar.gob"
+	publish2.Message.Payload = payload
+
+	publish3 := packet.NewPublish()
+	publish3.Message.Topic = "test/baz"
+	publish3.Message.Payload = []byte("invalid")
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe).
+		Send(suback).
+		Receive(publish1).
+		Receive(publish2).
+		Send(publish1).
+		Send(publish2).
+		Send(publish3).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	online := make(chan struct{})
+	offline := make(chan struct{})
+	values := make(chan codecValue, 2)
+	errs := make(chan error, 1)
+
+	s := NewService()
+
+	s.OnlineCallback = func(resumed bool) {
+		close(online)
+	}
+
+	s.OfflineCallback = func() {
+		close(offline)
+	}
+
+	s.ErrorCallback = func(err error) {
+		errs <- err
+	}
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	safeReceive(online)
+
+	sf := SubscribeValue(s, "test/+", 0, func(topic string, value codecValue) error {
+		values <- value
+		return nil
+	})
+	assert.NoError(t, sf.Wait(1*time.Second))
+
+	assert.NoError(t, s.PublishValue("test/foo", codecValue{Name: "foo", Count: 1}, 0, false).Wait(1*time.Second))
+	assert.NoError(t, s.PublishValue("test/bar.gob", codecValue{Name: "bar", Count: 2}, 0, false).Wait(1*time.Second))
+
+	assert.Equal(t, codecValue{Name: "foo", Count: 1}, <-values)
+	assert.Equal(t, codecValue{Name: "bar", Count: 2}, <-values)
+	assert.Error(t, <-errs)
+
+	assert.Error(t, s.PublishValue("test/foo", func() {}, 0, false).Wait(1*time.Second))
+	assert.Error(t, <-errs)
+
+	s.Stop(true)
+
+	safeReceive(offline)
+	safeReceive(done)
+}
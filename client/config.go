@@ -5,6 +5,11 @@ import (
 	"github.com/256dpi/gomqtt/transport"
 )
 
+// AutoProtocolVersion can be set as the protocol version to connect using the
+// newest supported version and fall back to older versions if the broker
+// rejects the version or closes the connection before acknowledging it.
+const AutoProtocolVersion byte = 0xFF
+
 // Dialer defines the dialer used by a client.
 type Dialer interface {
 	Dial(urlString string) (transport.Conn, error)
@@ -27,11 +32,41 @@ type Config struct {
 	// KeepAlive should be time duration string e.g. "30s".
 	KeepAlive string
 
+	// AdaptiveKeepAlive can be set to shorten the interval between pings on
+	// links with spiking round trip times and to expect pongs within a time
+	// derived from the measured round trip times (see NewAdaptiveTracker).
+	AdaptiveKeepAlive bool
+
 	// Will message is registered on the broker upon connect if set.
 	WillMessage *packet.Message
 
+	// ProtocolVersion can be set to use a specific protocol version (e.g.
+	// packet.Version31) or AutoProtocolVersion to negotiate the version.
+	//
+	// Will default to packet.Version311 if zero.
+	ProtocolVersion byte
+
 	// ValidateSubs will cause the client to fail if subscriptions failed.
 	ValidateSubs bool
+
+	// MaxInflight can be set to limit the number of outgoing QOS 1 and 2
+	// publishes that have not yet been acknowledged by the broker.
+	//
+	// Will default to unlimited if zero.
+	MaxInflight int
+
+	// InflightPolicy defines how publishes that exceed MaxInflight are handled.
+	//
+	// Will default to InflightQueue.
+	InflightPolicy InflightPolicy
+
+	// Dedup can be set to suppress duplicate QOS 1 messages that are
+	// redelivered by the broker.
+	Dedup *Dedup
+
+	// Compression can be set to transparently compress and decompress the
+	// payloads of published and received messages.
+	Compression *packet.Compression
 }
 
 // NewConfig creates a new Config using the specified URL.
@@ -0,0 +1,220 @@
+package client
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// DefaultDedupSize is the number of remembered keys that is used if neither a
+// size nor a window has been configured.
+const DefaultDedupSize = 1000
+
+// Dedup defines how duplicate QOS 1 messages are suppressed. The keys of
+// received messages are remembered and redelivered messages with a known key
+// are acknowledged without being passed to the callback or handler. The keys
+// are persisted in the session if it implements KeySession.
+//
+// Note: Without an extractor, messages are identified by their packet id and
+// fingerprint. A message flagged as a duplicate that has never been received
+// is therefore dropped if an earlier message with the same packet id, topic
+// and payload is still remembered. Configure an extractor if messages carry
+// an id to rule out this data loss.
+type Dedup struct {
+	// The function used to extract an application provided id from messages.
+	// If not set, the packet id and a fingerprint of the topic and payload is
+	// used and only messages flagged as duplicates are suppressed.
+	Extractor func(msg *packet.Message) string
+
+	// The maximum number of remembered keys. The least recently received keys
+	// are forgotten first.
+	//
+	// Will default to DefaultDedupSize if zero and no window is set.
+	Size int
+
+	// The duration keys are remembered. Keys are remembered until they are
+	// evicted if zero.
+	Window time.Duration
+}
+
+// A KeySession is a Session that can persist the keys of the duplicate
+// suppression window.
+type KeySession interface {
+	// SaveKey will store a key in the session.
+	SaveKey(key string, saved time.Time) error
+
+	// DeleteKey will remove a key from the session.
+	DeleteKey(key string) error
+
+	// AllKeys will return all keys currently saved in the session.
+	AllKeys() (map[string]time.Time, error)
+}
+
+// Fingerprint returns a key that identifies the message by its topic and
+// payload. Different messages with the same topic and payload share the same
+// fingerprint, which is why it should not be used as the only key to suppress
+// duplicates.
+func Fingerprint(msg *packet.Message) string {
+	hash := sha256.New()
+	hash.Write([]byte(msg.Topic))
+	hash.Write([]byte{0})
+	hash.Write(msg.Payload)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+type dedupEntry struct {
+	key  string
+	seen time.Time
+}
+
+// the dedup window remembers the keys of received messages
+type dedupWindow struct {
+	config  *Dedup
+	session KeySession
+	order   *list.List
+	entries map[string]*list.Element
+	mutex   sync.Mutex
+}
+
+func newDedupWindow(config *Dedup, sess Session) (*dedupWindow, error) {
+	// prepare window
+	w := &dedupWindow{
+		config:  config,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+
+	// check session
+	keySession, ok := sess.(KeySession)
+	if !ok {
+		return w, nil
+	}
+
+	// load keys
+	keys, err := keySession.AllKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	// sort keys
+	entries := make([]dedupEntry, 0, len(keys))
+	for key, seen := range keys {
+		entries = append(entries, dedupEntry{key: key, seen: seen})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].seen.Before(entries[j].seen)
+	})
+
+	// add keys
+	for _, entry := range entries {
+		w.entries[entry.key] = w.order.PushBack(entry)
+	}
+
+	// set session
+	w.session = keySession
+
+	// evict keys
+	err = w.evict()
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// key will return the key of the publish and whether it is a duplicate
+func (w *dedupWindow) key(publish *packet.Publish) (string, bool, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	// evict keys
+	err := w.evict()
+	if err != nil {
+		return "", false, err
+	}
+
+	// get key
+	var key string
+	if w.config.Extractor != nil {
+		key = w.config.Extractor(&publish.Message)
+	} else {
+		key = fmt.Sprintf("%d:%s", publish.ID, Fingerprint(&publish.Message))
+	}
+
+	// fingerprints only suppress messages flagged as duplicates
+	if w.config.Extractor == nil && !publish.Dup {
+		return key, false, nil
+	}
+
+	_, ok := w.entries[key]
+
+	return key, ok, nil
+}
+
+// remember will add the key to the window
+func (w *dedupWindow) remember(key string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	// prepare entry
+	entry := dedupEntry{key: key, seen: time.Now()}
+
+	// add or move entry
+	if element, ok := w.entries[key]; ok {
+		element.Value = entry
+		w.order.MoveToBack(element)
+	} else {
+		w.entries[key] = w.order.PushBack(entry)
+	}
+
+	// save key
+	if w.session != nil {
+		err := w.session.SaveKey(key, entry.seen)
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.evict()
+}
+
+// evict will remove keys that exceed the size or window
+func (w *dedupWindow) evict() error {
+	// get size
+	size := w.config.Size
+	if size <= 0 && w.config.Window <= 0 {
+		size = DefaultDedupSize
+	}
+
+	for {
+		// get oldest entry
+		element := w.order.Front()
+		if element == nil {
+			return nil
+		}
+		entry := element.Value.(dedupEntry)
+
+		// check size and window
+		if (size <= 0 || w.order.Len() <= size) && (w.config.Window <= 0 || time.Since(entry.seen) <= w.config.Window) {
+			return nil
+		}
+
+		// remove entry
+		w.order.Remove(element)
+		delete(w.entries, entry.key)
+
+		// delete key
+		if w.session != nil {
+			err := w.session.DeleteKey(entry.key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
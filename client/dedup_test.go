@@ -0,0 +1,182 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/session"
+	"github.com/256dpi/gomqtt/transport/flow"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupWindowSize(t *testing.T) {
+	sess := session.NewMemorySession()
+
+	w, err := newDedupWindow(&Dedup{Size: 2}, sess)
+	assert.NoError(t, err)
+
+	publish := packet.NewPublish()
+	publish.Message = packet.Message{Topic: "test", Payload: []byte("1")}
+	publish.ID = 1
+
+	key, duplicate, err := w.key(publish)
+	assert.NoError(t, err)
+	assert.Equal(t, "1:"+Fingerprint(&publish.Message), key)
+	assert.False(t, duplicate)
+
+	assert.NoError(t, w.remember(key))
+
+	_, duplicate, err = w.key(publish)
+	assert.NoError(t, err)
+	assert.False(t, duplicate)
+
+	publish.Dup = true
+
+	_, duplicate, err = w.key(publish)
+	assert.NoError(t, err)
+	assert.True(t, duplicate)
+
+	publish.ID = 2
+
+	_, duplicate, err = w.key(publish)
+	assert.NoError(t, err)
+	assert.False(t, duplicate)
+
+	publish.ID = 1
+
+	assert.NoError(t, w.remember("a"))
+	assert.NoError(t, w.remember("b"))
+
+	_, duplicate, err = w.key(publish)
+	assert.NoError(t, err)
+	assert.False(t, duplicate)
+
+	keys, err := sess.AllKeys()
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+	assert.Contains(t, keys, "a")
+	assert.Contains(t, keys, "b")
+
+	w, err = newDedupWindow(&Dedup{Size: 1}, sess)
+	assert.NoError(t, err)
+	assert.Len(t, w.entries, 1)
+	assert.Contains(t, w.entries, "b")
+
+	keys, err = sess.AllKeys()
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+}
+
+func TestDedupWindowTime(t *testing.T) {
+	w, err := newDedupWindow(&Dedup{
+		Extractor: func(msg *packet.Message) string {
+			return string(msg.Payload)
+		},
+		Window: 10 * time.Millisecond,
+	}, nil)
+	assert.NoError(t, err)
+
+	publish := packet.NewPublish()
+	publish.Message = packet.Message{Topic: "test", Payload: []byte("1")}
+
+	key, duplicate, err := w.key(publish)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", key)
+	assert.False(t, duplicate)
+
+	assert.NoError(t, w.remember(key))
+
+	_, duplicate, err = w.key(publish)
+	assert.NoError(t, err)
+	assert.True(t, duplicate)
+
+	time.Sleep(20 * time.Millisecond)
+
+	_, duplicate, err = w.key(publish)
+	assert.NoError(t, err)
+	assert.False(t, duplicate)
+	assert.Empty(t, w.entries)
+}
+
+func TestClientDedup(t *testing.T) {
+	publish := packet.NewPublish()
+	publish.Message.Topic = "test"
+	publish.Message.Payload = []byte("test")
+	publish.Message.QOS = 1
+	publish.ID = 1
+
+	dup := packet.NewPublish()
+	dup.Message = publish.Message
+	dup.Dup = true
+	dup.ID = 1
+
+	other := packet.NewPublish()
+	other.Message = publish.Message
+	other.Dup = true
+	other.ID = 2
+
+	puback1 := packet.NewPuback()
+	puback1.ID = 1
+
+	puback2 := packet.NewPuback()
+	puback2.ID = 2
+
+	connect := connectPacket()
+	connect.ClientID = "test"
+	connect.CleanSession = false
+
+	broker1 := flow.New().
+		Receive(connect).
+		Send(connackPacket()).
+		Send(publish).
+		Receive(puback1).
+		Send(dup).
+		Receive(puback1).
+		Receive(disconnectPacket()).
+		End()
+
+	broker2 := flow.New().
+		Receive(connect).
+		Send(connackPacket()).
+		Send(dup).
+		Receive(puback1).
+		Send(other).
+		Receive(puback2).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker1, broker2)
+
+	sess := session.NewMemorySession()
+
+	config := NewConfigWithClientID("tcp://localhost:"+port, "test")
+	config.CleanSession = false
+	config.Dedup = &Dedup{}
+
+	for i := 0; i < 2; i++ {
+		var counter int
+
+		c := New()
+		c.Session = sess
+		c.Callback = func(msg *packet.Message, err error) error {
+			assert.NoError(t, err)
+			assert.Equal(t, publish.Message, *msg)
+			counter++
+			return nil
+		}
+
+		connectFuture, err := c.Connect(config)
+		assert.NoError(t, err)
+		assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+		time.Sleep(50 * time.Millisecond)
+
+		assert.NoError(t, c.Disconnect())
+
+		assert.Equal(t, 1, counter)
+	}
+
+	safeReceive(done)
+}
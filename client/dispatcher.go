@@ -0,0 +1,124 @@
+package client
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/session"
+
+	"gopkg.in/tomb.v2"
+)
+
+// A Message is a received message that is passed to a Handler.
+type Message struct {
+	packet.Message
+
+	ack  func()
+	once sync.Once
+}
+
+// Ack will acknowledge the message. The broker will redeliver QOS 1 and 2
+// messages that have not been acknowledged when the session is resumed.
+// Additional calls have no effect.
+func (m *Message) Ack() {
+	m.once.Do(func() {
+		if m.ack != nil {
+			m.ack()
+		}
+	})
+}
+
+// A Handler is a function called by the workers of a client upon received
+// messages. Messages of the same topic are always handled by the same worker
+// and thus in order. The message must be acknowledged using Ack once it has
+// been processed. An error can be returned to close the client.
+//
+// Note: Messages of different topics may be acknowledged in a different order
+// than they have been received.
+type Handler func(msg *Message) error
+
+// dispatches a received publish packet to the worker responsible for its topic
+func (c *Client) dispatch(publish *packet.Publish, key string) error {
+	// prepare message
+	msg := &Message{
+		Message: publish.Message,
+	}
+
+	// prepare acknowledgement
+	switch publish.Message.QOS {
+	case 1:
+		msg.ack = func() {
+			// remember key
+			if key != "" {
+				err := c.dedup.remember(key)
+				if err != nil {
+					c.die(err, true, false)
+					return
+				}
+			}
+
+			// prepare puback packet
+			puback := packet.NewPuback()
+			puback.ID = publish.ID
+
+			// acknowledge qos 1 publish
+			err := c.send(puback, true)
+			if err != nil {
+				c.die(err, false, false)
+			}
+		}
+	case 2:
+		msg.ack = func() {
+			// prepare pubcomp packet
+			pubcomp := packet.NewPubcomp()
+			pubcomp.ID = publish.ID
+
+			// acknowledge qos 2 publish
+			err := c.send(pubcomp, true)
+			if err != nil {
+				c.die(err, false, false)
+				return
+			}
+
+			// remove packet from store
+			err = c.Session.DeletePacket(session.Incoming, publish.ID)
+			if err != nil {
+				c.die(err, true, false)
+			}
+		}
+	}
+
+	// select worker by topic
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(publish.Message.Topic))
+	queue := c.queues[hash.Sum32()%uint32(len(c.queues))]
+
+	// queue message
+	select {
+	case queue <- msg:
+		return nil
+	case <-c.tomb.Dying():
+		return tomb.ErrDying
+	}
+}
+
+/* worker goroutines */
+
+// passes queued messages to the handler
+func (c *Client) worker(queue chan *Message) func() error {
+	return func() error {
+		for {
+			select {
+			case msg := <-queue:
+				// call handler
+				err := c.Handler(msg)
+				if err != nil {
+					return c.die(err, true, false)
+				}
+			case <-c.tomb.Dying():
+				return tomb.ErrDying
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/transport/flow"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientHandler(t *testing.T) {
+	publish1 := packet.NewPublish()
+	publish1.Message.Topic = "test"
+	publish1.Message.Payload = []byte("1")
+	publish1.Message.QOS = 1
+	publish1.ID = 1
+
+	publish2 := packet.NewPublish()
+	publish2.Message.Topic = "test"
+	publish2.Message.Payload = []byte("2")
+	publish2.Message.QOS = 1
+	publish2.ID = 2
+
+	puback2 := packet.NewPuback()
+	puback2.ID = 2
+
+	publish3 := packet.NewPublish()
+	publish3.Message.Topic = "test"
+	publish3.Message.Payload = []byte("3")
+	publish3.Message.QOS = 2
+	publish3.ID = 3
+
+	pubrec3 := packet.NewPubrec()
+	pubrec3.ID = 3
+
+	pubrel3 := packet.NewPubrel()
+	pubrel3.ID = 3
+
+	pubcomp3 := packet.NewPubcomp()
+	pubcomp3.ID = 3
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Send(publish1).
+		Send(publish2).
+		Receive(puback2).
+		Send(publish3).
+		Receive(pubrec3).
+		Send(pubrel3).
+		Receive(pubcomp3).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	messages := make(chan string, 3)
+
+	c := New()
+	c.Callback = errorCallback(t)
+	c.Workers = 2
+	c.Handler = func(msg *Message) error {
+		messages <- string(msg.Payload)
+
+		// do not acknowledge first message
+		if string(msg.Payload) != "1" {
+			msg.Ack()
+		}
+
+		return nil
+	}
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	assert.Equal(t, "1", <-messages)
+	assert.Equal(t, "2", <-messages)
+	assert.Equal(t, "3", <-messages)
+
+	time.Sleep(20 * time.Millisecond)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientHandlerError(t *testing.T) {
+	publish := packet.NewPublish()
+	publish.Message.Topic = "test"
+	publish.Message.Payload = []byte("test")
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Send(publish).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	wait := make(chan struct{})
+
+	c := New()
+	c.Handler = func(msg *Message) error {
+		return errors.New("foo")
+	}
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.Nil(t, msg)
+		assert.EqualError(t, err, "foo")
+		close(wait)
+		return nil
+	}
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	safeReceive(wait)
+	safeReceive(done)
+}
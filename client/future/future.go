@@ -44,6 +44,27 @@ func (f *Future) Bind(f2 *Future) {
 	}
 }
 
+// All will return a future that is completed once all specified futures are
+// completed or canceled once one of them is canceled.
+func All(futures ...*Future) *Future {
+	f := New()
+
+	go func() {
+		for _, f2 := range futures {
+			select {
+			case <-f2.completeChannel:
+			case <-f2.cancelChannel:
+				close(f.cancelChannel)
+				return
+			}
+		}
+
+		close(f.completeChannel)
+	}()
+
+	return f
+}
+
 // Wait will wait the given amount of time and return whether the future has been
 // completed, canceled or the request timed out.
 func (f *Future) Wait(timeout time.Duration) error {
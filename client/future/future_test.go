@@ -96,3 +96,28 @@ func TestFutureBindAfter(t *testing.T) {
 
 	<-done
 }
+
+func TestAllComplete(t *testing.T) {
+	f1 := New()
+	f2 := New()
+
+	f := All(f1, f2)
+	assert.Equal(t, ErrTimeout, f.Wait(10*time.Millisecond))
+
+	f2.Complete()
+	assert.Equal(t, ErrTimeout, f.Wait(10*time.Millisecond))
+
+	f1.Complete()
+	assert.NoError(t, f.Wait(10*time.Millisecond))
+}
+
+func TestAllCancel(t *testing.T) {
+	f1 := New()
+	f2 := New()
+
+	f := All(f1, f2)
+
+	f1.Complete()
+	f2.Cancel()
+	assert.Equal(t, ErrCanceled, f.Wait(10*time.Millisecond))
+}
@@ -46,6 +46,14 @@ func (s *Store) Delete(id packet.ID) {
 	delete(s.store, id)
 }
 
+// Len will return the number of stored futures.
+func (s *Store) Len() int {
+	s.RLock()
+	defer s.RUnlock()
+
+	return len(s.store)
+}
+
 // All will return a slice with all stored futures.
 func (s *Store) All() []*Future {
 	s.RLock()
@@ -26,6 +26,10 @@ type ConnectFuture interface {
 
 	// ReturnCode will return the connack code returned by the broker.
 	ReturnCode() packet.ConnackCode
+
+	// Version will return the protocol version that has been used for the
+	// connection.
+	Version() byte
 }
 
 // A SubscribeFuture is returned by the subscribe methods.
@@ -42,6 +46,7 @@ const (
 	sessionPresentKey futureKey = iota
 	returnCodeKey
 	returnCodesKey
+	versionKey
 )
 
 type connectFuture struct {
@@ -66,6 +71,15 @@ func (f *connectFuture) ReturnCode() packet.ConnackCode {
 	return v.(packet.ConnackCode)
 }
 
+func (f *connectFuture) Version() byte {
+	v, ok := f.Data.Load(versionKey)
+	if !ok {
+		return 0
+	}
+
+	return v.(byte)
+}
+
 type subscribeFuture struct {
 	*future.Future
 }
@@ -0,0 +1,188 @@
+package client
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// ErrInflightLimitReached is returned by Publish if the inflight window is
+// full and the InflightFail policy is used.
+var ErrInflightLimitReached = errors.New("inflight limit reached")
+
+// An InflightPolicy defines how publishes are handled that exceed the maximum
+// number of inflight messages.
+type InflightPolicy int
+
+const (
+	// InflightQueue will queue publishes and send them in order once the
+	// window has space available.
+	InflightQueue InflightPolicy = iota
+
+	// InflightBlock will block publishes until the window has space available.
+	InflightBlock
+
+	// InflightFail will fail publishes with ErrInflightLimitReached.
+	InflightFail
+)
+
+// the inflight window tracks the ids of outgoing publishes that have not been
+// completed yet
+type inflightWindow struct {
+	limit  int
+	policy InflightPolicy
+
+	ids      map[packet.ID]struct{}
+	reserved int
+	queue    []*packet.Publish
+	signal   chan struct{}
+	mutex    sync.Mutex
+}
+
+func newInflightWindow(limit int, policy InflightPolicy) *inflightWindow {
+	return &inflightWindow{
+		limit:  limit,
+		policy: policy,
+		ids:    make(map[packet.ID]struct{}),
+		signal: make(chan struct{}),
+	}
+}
+
+// reserve will either reserve space in the window if available or return an
+// error or block according to the policy. No space is reserved for publishes
+// that should be queued.
+func (w *inflightWindow) reserve(cancel, closing <-chan struct{}) error {
+	for {
+		w.mutex.Lock()
+
+		// check queue policy
+		if w.limit <= 0 || w.policy == InflightQueue {
+			w.mutex.Unlock()
+			return nil
+		}
+
+		// reserve space if available
+		if len(w.ids)+w.reserved < w.limit {
+			w.reserved++
+			w.mutex.Unlock()
+			return nil
+		}
+
+		// fail if requested
+		if w.policy == InflightFail {
+			w.mutex.Unlock()
+			return ErrInflightLimitReached
+		}
+
+		// get signal
+		signal := w.signal
+
+		w.mutex.Unlock()
+
+		// wait for a release
+		select {
+		case <-signal:
+		case <-cancel:
+			return ErrClientNotConnected
+		case <-closing:
+			return ErrClientNotConnected
+		}
+	}
+}
+
+// blocks will return whether reserve would block until space is released
+func (w *inflightWindow) blocks() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.limit > 0 && w.policy == InflightBlock && len(w.ids)+w.reserved >= w.limit
+}
+
+// add will add the publish to the window or queue it if the window is full. It
+// will return false if the publish has been queued.
+func (w *inflightWindow) add(publish *packet.Publish) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	// use reserved space
+	if w.reserved > 0 {
+		w.reserved--
+		w.ids[publish.ID] = struct{}{}
+		return true
+	}
+
+	// queue publish if full or other publishes are already queued
+	if w.limit > 0 && w.policy == InflightQueue && (len(w.ids) >= w.limit || len(w.queue) > 0) {
+		w.queue = append(w.queue, publish)
+		return false
+	}
+
+	// add id
+	w.ids[publish.ID] = struct{}{}
+
+	return true
+}
+
+// unreserve will release reserved space that has not been used
+func (w *inflightWindow) unreserve() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	// release space
+	w.reserved--
+
+	// wake up blocked publishes
+	close(w.signal)
+	w.signal = make(chan struct{})
+}
+
+// mark will add the supplied id to the window without checking the limit
+func (w *inflightWindow) mark(id packet.ID) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.ids[id] = struct{}{}
+}
+
+// release will remove the id from the window and return the next queued
+// publish that should be sent
+func (w *inflightWindow) release(id packet.ID) *packet.Publish {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	// check id
+	if _, ok := w.ids[id]; !ok {
+		return nil
+	}
+
+	// remove id
+	delete(w.ids, id)
+
+	// wake up blocked publishes
+	close(w.signal)
+	w.signal = make(chan struct{})
+
+	// return if the window is still full or nothing has been queued
+	if (w.limit > 0 && len(w.ids) >= w.limit) || len(w.queue) == 0 {
+		return nil
+	}
+
+	// dequeue publish
+	publish := w.queue[0]
+	w.queue[0] = nil
+	w.queue = w.queue[1:]
+
+	// add id
+	w.ids[publish.ID] = struct{}{}
+
+	return publish
+}
+
+// size will return the number of inflight and queued publishes
+func (w *inflightWindow) size() (int, int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return len(w.ids), len(w.queue)
+}
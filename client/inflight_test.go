@@ -0,0 +1,249 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/transport/flow"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func inflightPackets(n int) ([]*packet.Publish, []*packet.Puback) {
+	var publishes []*packet.Publish
+	var pubacks []*packet.Puback
+
+	for i := 1; i <= n; i++ {
+		publish := packet.NewPublish()
+		publish.Message.Topic = "test"
+		publish.Message.Payload = []byte("test")
+		publish.Message.QOS = 1
+		publish.ID = packet.ID(i)
+		publishes = append(publishes, publish)
+
+		puback := packet.NewPuback()
+		puback.ID = packet.ID(i)
+		pubacks = append(pubacks, puback)
+	}
+
+	return publishes, pubacks
+}
+
+func TestClientInflightQueue(t *testing.T) {
+	publishes, pubacks := inflightPackets(3)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(publishes[0]).
+		Run(func() {
+			time.Sleep(20 * time.Millisecond)
+
+			inflight, queued := c.inflight.size()
+			assert.Equal(t, 1, inflight)
+			assert.Equal(t, 2, queued)
+		}).
+		Send(pubacks[0]).
+		Receive(publishes[1]).
+		Send(pubacks[1]).
+		Receive(publishes[2]).
+		Send(pubacks[2]).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.MaxInflight = 1
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	var futures []GenericFuture
+	for i := 0; i < 3; i++ {
+		publishFuture, err := c.Publish("test", []byte("test"), 1, false)
+		assert.NoError(t, err)
+		futures = append(futures, publishFuture)
+	}
+
+	for _, publishFuture := range futures {
+		assert.NoError(t, publishFuture.Wait(1*time.Second))
+	}
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientInflightFail(t *testing.T) {
+	publishes, pubacks := inflightPackets(2)
+
+	qos0 := packet.NewPublish()
+	qos0.Message.Topic = "test"
+	qos0.Message.Payload = []byte("test")
+
+	wait := make(chan struct{})
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(publishes[0]).
+		Receive(qos0).
+		Run(func() {
+			<-wait
+		}).
+		Send(pubacks[0]).
+		Receive(publishes[1]).
+		Send(pubacks[1]).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.MaxInflight = 1
+	config.InflightPolicy = InflightFail
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	publishFuture, err := c.Publish("test", []byte("test"), 1, false)
+	assert.NoError(t, err)
+
+	_, err = c.Publish("test", []byte("test"), 1, false)
+	assert.Equal(t, ErrInflightLimitReached, err)
+
+	qos0Future, err := c.Publish("test", []byte("test"), 0, false)
+	assert.NoError(t, err)
+	assert.NoError(t, qos0Future.Wait(1*time.Second))
+
+	close(wait)
+	assert.NoError(t, publishFuture.Wait(1*time.Second))
+
+	publishFuture, err = c.Publish("test", []byte("test"), 1, false)
+	assert.NoError(t, err)
+	assert.NoError(t, publishFuture.Wait(1*time.Second))
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientInflightBlock(t *testing.T) {
+	publishes, pubacks := inflightPackets(2)
+
+	released := make(chan struct{})
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(publishes[0]).
+		Run(func() {
+			time.Sleep(20 * time.Millisecond)
+			close(released)
+		}).
+		Send(pubacks[0]).
+		Receive(publishes[1]).
+		Send(pubacks[1]).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.MaxInflight = 1
+	config.InflightPolicy = InflightBlock
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	publishFuture, err := c.Publish("test", []byte("test"), 1, false)
+	assert.NoError(t, err)
+
+	publishFuture2, err := c.Publish("test", []byte("test"), 1, false)
+	assert.NoError(t, err)
+
+	select {
+	case <-released:
+	default:
+		assert.Fail(t, "publish should have been blocked")
+	}
+
+	assert.NoError(t, publishFuture.Wait(1*time.Second))
+	assert.NoError(t, publishFuture2.Wait(1*time.Second))
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientInflightBlockClose(t *testing.T) {
+	publishes, _ := inflightPackets(1)
+
+	connect := connectPacket()
+	connect.KeepAlive = 0
+
+	broker := flow.New().
+		Receive(connect).
+		Send(connackPacket()).
+		Receive(publishes[0]).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.KeepAlive = "0s"
+	config.MaxInflight = 1
+	config.InflightPolicy = InflightBlock
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	publishFuture, err := c.Publish("test", []byte("test"), 1, false)
+	assert.NoError(t, err)
+
+	blocked := make(chan struct{})
+
+	go func() {
+		_, err := c.Publish("test", []byte("test"), 1, false)
+		assert.Equal(t, ErrClientNotConnected, err)
+		close(blocked)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+
+	closed := make(chan struct{})
+
+	go func() {
+		err := c.Close()
+		assert.NoError(t, err)
+		close(closed)
+	}()
+
+	safeReceive(closed)
+	safeReceive(blocked)
+
+	assert.Error(t, publishFuture.Wait(1*time.Second))
+
+	safeReceive(done)
+}
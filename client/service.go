@@ -1,8 +1,8 @@
 package client
 
 import (
+	"errors"
 	"fmt"
-	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -16,6 +16,10 @@ import (
 	"gopkg.in/tomb.v2"
 )
 
+// ErrMissingConfig is emitted if the ConfigProvider returned no config and no
+// config has been passed to Start.
+var ErrMissingConfig = errors.New("missing config")
+
 type command struct {
 	publish     bool
 	subscribe   bool
@@ -47,12 +51,48 @@ type MessageCallback func(*packet.Message) error
 // means that waiting on a future inside the callback will deadlock the service.
 type ErrorCallback func(error)
 
+// A ConfigProvider is a function that is called before each connection attempt
+// to obtain a fresh config, e.g. with renewed credentials, a dialer with new TLS
+// certificates or a changed will message. If the returned config is nil, the
+// config passed to Start is used. If an error is returned, the attempt is
+// aborted and retried after the reconnect delay.
+type ConfigProvider func() (*Config, error)
+
 // An OfflineCallback is a function that is called when the service is disconnected.
 //
 // Note: Execution of the service is resumed after the callback returns. This
 // means that waiting on a future inside the callback will deadlock the service.
 type OfflineCallback func()
 
+// A DeniedCallback is a function that is called when the broker denied the
+// connection and the DenialEscalate action has been configured for the return
+// code. The service will stop reconnecting unless the callback returns true.
+//
+// Note: Execution of the service is resumed after the callback returns. This
+// means that waiting on a future inside the callback will deadlock the service.
+type DeniedCallback func(code packet.ConnackCode) bool
+
+// A DenialAction defines how the service handles a connection that has been
+// denied by the broker.
+type DenialAction int
+
+const (
+	// DenialRetry will retry the connection after the reconnect delay.
+	DenialRetry DenialAction = iota
+
+	// DenialStop will stop reconnecting until the service is restarted.
+	DenialStop
+
+	// DenialEscalate will call the DeniedCallback to decide whether to retry
+	// or stop reconnecting.
+	DenialEscalate
+
+	// DenialDowngrade will retry the connection using protocol version 3.1 if
+	// the connection has been attempted with version 3.1.1 and stop
+	// reconnecting otherwise.
+	DenialDowngrade
+)
+
 const (
 	serviceStarted uint32 = iota
 	serviceStopped
@@ -83,14 +123,49 @@ type Service struct {
 	// The callback to be called by the service upon encountering an error.
 	ErrorCallback ErrorCallback
 
+	// The codec that is used by PublishValue and SubscribeValue if no codec
+	// matches the content type of the topic.
+	//
+	// Will default to JSONCodec.
+	Codec Codec
+
+	// Additional codecs that are selected using the content type of the topic.
+	// The builtin JSONCodec and GobCodec are always available.
+	Codecs []Codec
+
 	// The callback that is used to notify that the service is offline.
 	OfflineCallback OfflineCallback
 
+	// The configuration used to publish and reassemble chunked messages.
+	// Received messages are only reassembled if set.
+	//
+	// Note: The value must be changed before calling Start.
+	Chunking *Chunking
+
+	// The provider that is called to obtain the config for each connection
+	// attempt.
+	ConfigProvider ConfigProvider
+
+	// The callback that is used to escalate denied connections.
+	DeniedCallback DeniedCallback
+
+	// The actions that are taken if the broker denies the connection with
+	// the specified return code. Return codes without an action are retried.
+	// Once halted, queued and new commands are canceled until the service is
+	// stopped.
+	//
+	// Will default to an empty map that retries all denied connections.
+	DenialActions map[packet.ConnackCode]DenialAction
+
 	// The logger that is used to log write low level information like packets
 	// that have ben successfully sent and received, details about the
 	// automatic keep alive handler, reconnection and occurring errors.
 	Logger Logger
 
+	// The packet logger that is used instead of the logger to log sent and
+	// received packets if set.
+	PacketLogger PacketLogger
+
 	// The minimum delay between reconnects.
 	//
 	// Note: The value must be changed before calling Start.
@@ -113,16 +188,25 @@ type Service struct {
 
 	// Whether to resubscribe all subscriptions after reconnecting. Can be
 	// disabled if the broker supports persistent sessions and the client is
-	// configured to request one.
+	// configured to request one. Failed and pending subscriptions are always
+	// retried.
 	ResubscribeAllSubscriptions bool
 
+	version       byte
+	reassembler   *reassembler
+	status        status
+	counters      *counters
 	backoff       *backoff.Backoff
-	subscriptions *topic.Tree
+	subscriptions *topic.TypedTree[Subscription]
+	handlers      *topic.TypedTree[*valueHandler]
 	commandQueue  chan *command
 	futureStore   *future.Store
 
-	mutex sync.Mutex
-	tomb  *tomb.Tomb
+	mutex              sync.Mutex
+	commandMutex       sync.Mutex
+	statusMutex        sync.Mutex
+	subscriptionsMutex sync.Mutex
+	tomb               *tomb.Tomb
 }
 
 // NewService allocates and returns a new service. The optional parameter queueSize
@@ -143,16 +227,23 @@ func NewService(queueSize ...int) *Service {
 		DisconnectTimeout:           10 * time.Second,
 		ResubscribeTimeout:          5 * time.Second,
 		ResubscribeAllSubscriptions: true,
-		subscriptions:               topic.NewTree(),
+		DenialActions:               map[packet.ConnackCode]DenialAction{},
+		subscriptions:               topic.NewTypedTree[Subscription](nil),
+		handlers:                    topic.NewTypedTree[*valueHandler](nil),
 		commandQueue:                make(chan *command, qs),
 		futureStore:                 future.NewStore(),
+		counters:                    &counters{},
+		status: status{
+			watchers: make(map[chan ServiceState]struct{}),
+		},
 	}
 }
 
 // Start will start the service with the specified configuration. From now on
 // the service will automatically reconnect on any error until Stop is called.
+// The config may be nil if a ConfigProvider is set.
 func (s *Service) Start(config *Config) {
-	if config == nil {
+	if config == nil && s.ConfigProvider == nil {
 		panic("no config specified")
 	}
 
@@ -170,6 +261,18 @@ func (s *Service) Start(config *Config) {
 	// save config
 	s.config = config
 
+	// reset protocol version
+	s.version = 0
+
+	// reset status
+	s.update(func(status *status) {
+		status.state = ServiceConnecting
+		status.brokerURL = ""
+		status.reconnects = 0
+		status.lastError = nil
+		s.counters = &counters{}
+	})
+
 	// initialize backoff
 	s.backoff = &backoff.Backoff{
 		Min:    s.MinReconnectDelay,
@@ -177,6 +280,12 @@ func (s *Service) Start(config *Config) {
 		Factor: 2,
 	}
 
+	// prepare reassembler
+	s.reassembler = nil
+	if s.Chunking != nil {
+		s.reassembler = newReassembler(s.Chunking)
+	}
+
 	// mark future store as protected
 	s.futureStore.Protect(true)
 
@@ -185,6 +294,11 @@ func (s *Service) Start(config *Config) {
 
 	// start supervisor
 	s.tomb.Go(s.supervisor)
+
+	// start expirer
+	if s.reassembler != nil {
+		s.tomb.Go(s.expirer)
+	}
 }
 
 // Publish will send a Publish packet containing the passed parameters. It will
@@ -205,8 +319,8 @@ func (s *Service) Publish(topic string, payload []byte, qos packet.QOS, retain b
 // return a PublishFuture that gets completed once the quality of service flow
 // has been completed.
 func (s *Service) PublishMessage(msg *packet.Message) GenericFuture {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	s.commandMutex.Lock()
+	defer s.commandMutex.Unlock()
 
 	// allocate future
 	f := future.New()
@@ -234,13 +348,15 @@ func (s *Service) Subscribe(topic string, qos packet.QOS) SubscribeFuture {
 // subscribe. It will return a SubscribeFuture that gets completed once the
 // acknowledgements have been received.
 func (s *Service) SubscribeMultiple(subscriptions []packet.Subscription) SubscribeFuture {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	s.commandMutex.Lock()
+	defer s.commandMutex.Unlock()
 
-	// save subscription
+	// save subscriptions
+	s.subscriptionsMutex.Lock()
 	for _, v := range subscriptions {
-		s.subscriptions.Set(v.Topic, v)
+		s.subscriptions.Set(v.Topic, Subscription{Subscription: v})
 	}
+	s.subscriptionsMutex.Unlock()
 
 	// allocate future
 	f := future.New()
@@ -266,13 +382,16 @@ func (s *Service) Unsubscribe(topic string) GenericFuture {
 // topics to unsubscribe. It will return a SubscribeFuture that gets completed
 // once the acknowledgements have been received.
 func (s *Service) UnsubscribeMultiple(topics []string) GenericFuture {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	s.commandMutex.Lock()
+	defer s.commandMutex.Unlock()
 
-	// remove subscription
+	// remove subscriptions and handlers
+	s.subscriptionsMutex.Lock()
 	for _, v := range topics {
 		s.subscriptions.Empty(v)
+		s.handlers.Empty(v)
 	}
+	s.subscriptionsMutex.Unlock()
 
 	// allocate future
 	f := future.New()
@@ -305,6 +424,11 @@ func (s *Service) Stop(clearFutures bool) {
 	s.tomb.Kill(nil)
 	s.tomb.Wait()
 
+	// update status
+	s.update(func(status *status) {
+		status.state = ServiceStopped
+	})
+
 	// clear futures if requested
 	if clearFutures {
 		s.futureStore.Protect(false)
@@ -324,6 +448,11 @@ func (s *Service) supervisor() error {
 			// no delay on first attempt
 			first = false
 		} else {
+			// count reconnect
+			s.update(func(status *status) {
+				status.reconnects++
+			})
+
 			// get backoff duration
 			d := s.backoff.Duration()
 			s.log(fmt.Sprintf("Delay Reconnect: %v", d))
@@ -342,18 +471,32 @@ func (s *Service) supervisor() error {
 		fail := make(chan struct{})
 
 		// try once to get a client
-		client, resumed := s.connect(fail)
+		client, resumed, code := s.connect(fail)
 		if client == nil {
+			// handle denied connections
+			if code != packet.ConnectionAccepted && !s.denied(code) {
+				s.log("Stop Reconnect")
+				s.update(func(status *status) {
+					status.state = ServiceHalted
+				})
+				return s.halt()
+			}
+
 			continue
 		}
 
 		// resubscribe
-		if s.ResubscribeAllSubscriptions {
-			if !s.resubscribe(client) {
-				continue
-			}
+		if !s.resubscribe(client) {
+			continue
 		}
 
+		// update status
+		s.update(func(status *status) {
+			status.state = ServiceOnline
+			status.connectedSince = time.Now()
+			status.client = client
+		})
+
 		// run callback
 		if s.OnlineCallback != nil {
 			s.OnlineCallback(resumed)
@@ -362,6 +505,15 @@ func (s *Service) supervisor() error {
 		// run dispatcher on client
 		dying := s.dispatcher(client, fail)
 
+		// update status
+		s.update(func(status *status) {
+			if !dying {
+				status.state = ServiceConnecting
+			}
+			status.connectedSince = time.Time{}
+			status.client = nil
+		})
+
 		// run callback
 		if s.OfflineCallback != nil {
 			s.OfflineCallback()
@@ -374,13 +526,30 @@ func (s *Service) supervisor() error {
 	}
 }
 
+// cancels queued and new commands until the service is stopped
+func (s *Service) halt() error {
+	for {
+		select {
+		case cmd := <-s.commandQueue:
+			cmd.future.Cancel()
+		case <-s.tomb.Dying():
+			return tomb.ErrDying
+		}
+	}
+}
+
 // will try to connect one client to the broker
-func (s *Service) connect(fail chan struct{}) (*Client, bool) {
+func (s *Service) connect(fail chan struct{}) (*Client, bool, packet.ConnackCode) {
 	// prepare new client
 	client := New()
 	client.Session = s.Session
 	client.Logger = s.Logger
+	client.PacketLogger = s.PacketLogger
+	client.ErrorCallback = func(err error) {
+		s.err("Compression", err)
+	}
 	client.futureStore = s.futureStore
+	client.counters = s.counters
 
 	// set callback
 	client.Callback = func(msg *packet.Message, err error) error {
@@ -390,6 +559,23 @@ func (s *Service) connect(fail chan struct{}) (*Client, bool) {
 			return nil
 		}
 
+		// reassemble chunked messages
+		if s.reassembler != nil {
+			msg, err = s.reassembler.process(msg)
+			if err != nil {
+				s.err("Chunking", err)
+			}
+			if msg == nil {
+				return nil
+			}
+		}
+
+		// call value handlers
+		err = s.handle(msg)
+		if err != nil {
+			return err
+		}
+
 		// call the handler
 		if s.MessageCallback != nil {
 			return s.MessageCallback(msg)
@@ -398,20 +584,58 @@ func (s *Service) connect(fail chan struct{}) (*Client, bool) {
 		return nil
 	}
 
+	// get config
+	config := s.config
+	if s.ConfigProvider != nil {
+		providedConfig, err := s.ConfigProvider()
+		if err != nil {
+			s.err("ConfigProvider", err)
+			return nil, false, packet.ConnectionAccepted
+		}
+
+		// use provided config
+		if providedConfig != nil {
+			config = providedConfig
+		}
+	}
+
+	// check config
+	if config == nil {
+		s.err("ConfigProvider", ErrMissingConfig)
+		return nil, false, packet.ConnectionAccepted
+	}
+
+	// save broker url
+	s.update(func(status *status) {
+		status.brokerURL = config.BrokerURL
+	})
+
+	// apply protocol version
+	if s.version != 0 && config.ProtocolVersion != s.version {
+		versionedConfig := *config
+		versionedConfig.ProtocolVersion = s.version
+		config = &versionedConfig
+	}
+
 	// attempt to connect
-	connectFuture, err := client.Connect(s.config)
+	connectFuture, err := client.Connect(config)
 	if err != nil {
 		s.err("Connect", err)
-		return nil, false
+		return nil, false, packet.ConnectionAccepted
 	}
 
 	// wait for connack
 	err = connectFuture.Wait(s.ConnectTimeout)
 
+	// check if connection has been denied (already emitted by the client)
+	if err == future.ErrCanceled && connectFuture.ReturnCode() != packet.ConnectionAccepted {
+		return nil, false, connectFuture.ReturnCode()
+	}
+
 	// check if future has been canceled
 	if err == future.ErrCanceled {
 		s.err("Connect", err)
-		return nil, false
+		return nil, false, packet.ConnectionAccepted
 	}
 
 	// check if future has timed out
@@ -419,31 +643,45 @@ func (s *Service) connect(fail chan struct{}) (*Client, bool) {
 		client.Close()
 
 		s.err("Connect", err)
-		return nil, false
+		return nil, false, packet.ConnectionAccepted
 	}
 
-	return client, connectFuture.SessionPresent()
+	return client, connectFuture.SessionPresent(), packet.ConnectionAccepted
 }
 
-func (s *Service) resubscribe(client *Client) bool {
-	// get all subscriptions and return if empty
-	items := s.subscriptions.All()
-	if len(items) == 0 {
+// returns whether the service should reconnect after a denied connection
+func (s *Service) denied(code packet.ConnackCode) bool {
+	switch s.DenialActions[code] {
+	case DenialStop:
+		return false
+	case DenialEscalate:
+		if s.DeniedCallback != nil {
+			return s.DeniedCallback(code)
+		}
+
+		return false
+	case DenialDowngrade:
+		if s.version == packet.Version31 {
+			return false
+		}
+
+		s.log("Downgrade Protocol Version")
+		s.version = packet.Version31
+
 		return true
 	}
 
-	// prepare subscriptions
-	subs := make([]packet.Subscription, 0, len(items))
-	for _, v := range items {
-		subs = append(subs, v.(packet.Subscription))
-	}
+	return true
+}
 
-	// sort subscriptions
-	sort.Slice(subs, func(i, j int) bool {
-		return subs[i].Topic < subs[j].Topic
-	})
+func (s *Service) resubscribe(client *Client) bool {
+	// get pending subscriptions and return if empty
+	subs := s.pendingSubscriptions()
+	if len(subs) == 0 {
+		return true
+	}
 
-	// resubscribe all subscriptions
+	// resubscribe subscriptions
 	subscribeFuture, err := client.SubscribeMultiple(subs)
 	if err != nil {
 		s.err("Resubscribe", err)
@@ -453,6 +691,9 @@ func (s *Service) resubscribe(client *Client) bool {
 	// wait for suback.
 	err = subscribeFuture.Wait(s.ResubscribeTimeout)
 
+	// update subscriptions
+	s.acknowledge(subs, subscribeFuture.ReturnCodes())
+
 	// check if future has been canceled
 	if err == future.ErrCanceled {
 		s.err("Resubscribe", err)
@@ -488,9 +729,15 @@ func (s *Service) dispatcher(client *Client, fail chan struct{}) bool {
 					return false
 				}
 
-				// bind future in a own goroutine. the goroutine will be
-				// ultimately collected when the service is stopped
-				go cmd.future.Bind(f2.(*subscribeFuture).Future)
+				// update subscriptions and bind future in a own goroutine.
+				// the goroutine will be ultimately collected when the
+				// service is stopped
+				go func(cmd *command, f2 SubscribeFuture) {
+					ack := future.New()
+					ack.Bind(f2.(*subscribeFuture).Future)
+					s.acknowledge(cmd.subscriptions, f2.ReturnCodes())
+					cmd.future.Bind(ack)
+				}(cmd, f2)
 			}
 
 			// handle unsubscribe command
@@ -543,6 +790,11 @@ func (s *Service) dispatcher(client *Client, fail chan struct{}) bool {
 func (s *Service) err(sys string, err error) {
 	s.log(fmt.Sprintf("%s Error: %s", sys, err.Error()))
 
+	// save error
+	s.update(func(status *status) {
+		status.lastError = err
+	})
+
 	if s.ErrorCallback != nil {
 		s.ErrorCallback(err)
 	}
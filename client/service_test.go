@@ -1,9 +1,12 @@
 package client
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/256dpi/gomqtt/client/future"
 	"github.com/256dpi/gomqtt/packet"
 	"github.com/256dpi/gomqtt/transport/flow"
 
@@ -268,6 +271,284 @@ func TestServiceReconnect(t *testing.T) {
 	assert.Equal(t, 4, i)
 }
 
+func TestServiceConfigProvider(t *testing.T) {
+	connect1 := connectPacket()
+	connect1.Username = "user1"
+
+	connect2 := connectPacket()
+	connect2.Username = "user2"
+
+	broker1 := flow.New().
+		Receive(connect1).
+		Send(connackPacket()).
+		Close()
+
+	broker2 := flow.New().
+		Receive(connect2).
+		Send(connackPacket()).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker1, broker2)
+
+	online := make(chan struct{}, 2)
+	offline := make(chan struct{}, 2)
+
+	s := NewService()
+	s.MinReconnectDelay = 10 * time.Millisecond
+
+	errors := 0
+	s.ErrorCallback = func(err error) {
+		if err.Error() == "failed" {
+			errors++
+		}
+	}
+
+	i := 0
+	s.ConfigProvider = func() (*Config, error) {
+		i++
+
+		// fail first attempt
+		if i == 1 {
+			return nil, fmt.Errorf("failed")
+		}
+
+		return NewConfig(fmt.Sprintf("tcp://user%d@localhost:%s", i-1, port)), nil
+	}
+
+	s.OnlineCallback = func(resumed bool) {
+		assert.False(t, resumed)
+		online <- struct{}{}
+	}
+
+	s.OfflineCallback = func() {
+		offline <- struct{}{}
+	}
+
+	s.Start(nil)
+
+	safeReceive(online)
+	safeReceive(offline)
+	safeReceive(online)
+
+	s.Stop(true)
+
+	safeReceive(offline)
+	safeReceive(done)
+
+	assert.Equal(t, 3, i)
+	assert.Equal(t, 1, errors)
+}
+
+func TestServiceDenialEscalate(t *testing.T) {
+	connack := connackPacket()
+	connack.ReturnCode = packet.NotAuthorized
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connack).
+		Close()
+
+	done, port := fakeBroker(t, broker, broker)
+
+	denied := make(chan struct{})
+
+	s := NewService()
+	s.MinReconnectDelay = 10 * time.Millisecond
+	s.DenialActions[packet.NotAuthorized] = DenialEscalate
+
+	i := 0
+	s.DeniedCallback = func(code packet.ConnackCode) bool {
+		assert.Equal(t, packet.NotAuthorized, code)
+		i++
+
+		// stop on second denial
+		if i == 2 {
+			close(denied)
+			return false
+		}
+
+		return true
+	}
+
+	s.OnlineCallback = func(bool) {
+		assert.Fail(t, "should not be called")
+	}
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	safeReceive(denied)
+	safeReceive(done)
+
+	s.Stop(true)
+
+	assert.Equal(t, 2, i)
+}
+
+func TestServiceDenialStop(t *testing.T) {
+	connack := connackPacket()
+	connack.ReturnCode = packet.ServerUnavailable
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connack).
+		Close()
+
+	done, port := fakeBroker(t, broker)
+
+	stopped := make(chan struct{})
+
+	s := NewService()
+	s.DenialActions[packet.ServerUnavailable] = DenialStop
+
+	var denied error
+	s.ErrorCallback = func(err error) {
+		if errors.Is(err, ErrClientConnectionDenied) {
+			denied = err
+		}
+	}
+
+	s.Logger = func(msg string) {
+		if msg == "Stop Reconnect" {
+			close(stopped)
+		}
+	}
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	safeReceive(stopped)
+	safeReceive(done)
+
+	s.Stop(true)
+
+	assert.Equal(t, &ConnectionDeniedError{Code: packet.ServerUnavailable}, denied)
+}
+
+func TestServiceDenialDowngrade(t *testing.T) {
+	connack := connackPacket()
+	connack.ReturnCode = packet.InvalidProtocolVersion
+
+	connect := connectPacket()
+	connect.Version = packet.Version31
+
+	broker1 := flow.New().
+		Receive(connectPacket()).
+		Send(connack).
+		Close()
+
+	broker2 := flow.New().
+		Receive(connect).
+		Send(connackPacket()).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker1, broker2)
+
+	online := make(chan struct{})
+	offline := make(chan struct{})
+
+	s := NewService()
+	s.MinReconnectDelay = 10 * time.Millisecond
+	s.DenialActions[packet.InvalidProtocolVersion] = DenialDowngrade
+
+	s.OnlineCallback = func(resumed bool) {
+		assert.False(t, resumed)
+		close(online)
+	}
+
+	s.OfflineCallback = func() {
+		close(offline)
+	}
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	safeReceive(online)
+
+	s.Stop(true)
+
+	safeReceive(offline)
+	safeReceive(done)
+}
+
+func TestServiceDenialRetry(t *testing.T) {
+	connack := connackPacket()
+	connack.ReturnCode = packet.NotAuthorized
+
+	broker1 := flow.New().
+		Receive(connectPacket()).
+		Send(connack).
+		Close()
+
+	broker2 := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker1, broker2)
+
+	online := make(chan struct{})
+	offline := make(chan struct{})
+
+	s := NewService()
+	s.MinReconnectDelay = 10 * time.Millisecond
+
+	s.OnlineCallback = func(resumed bool) {
+		close(online)
+	}
+
+	s.OfflineCallback = func() {
+		close(offline)
+	}
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	safeReceive(online)
+
+	s.Stop(true)
+
+	safeReceive(offline)
+	safeReceive(done)
+}
+
+func TestServiceDenialStopCommands(t *testing.T) {
+	connack := connackPacket()
+	connack.ReturnCode = packet.NotAuthorized
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connack).
+		Close()
+
+	done, port := fakeBroker(t, broker)
+
+	s := NewService(1)
+	s.DenialActions[packet.NotAuthorized] = DenialStop
+
+	states, stop := s.Watch()
+	defer stop()
+
+	queued := s.Publish("test", []byte("test"), 0, false)
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	assert.Equal(t, ServiceConnecting, <-states)
+	assert.Equal(t, ServiceHalted, <-states)
+
+	safeReceive(done)
+
+	assert.Equal(t, future.ErrCanceled, queued.Wait(1*time.Second))
+
+	for i := 0; i < 3; i++ {
+		f := s.Publish("test", []byte("test"), 0, false)
+		assert.Equal(t, future.ErrCanceled, f.Wait(1*time.Second))
+	}
+
+	s.Stop(true)
+
+	assert.Equal(t, ServiceStopped, <-states)
+}
+
 func TestServiceResubscribe(t *testing.T) {
 	subscribe1 := packet.NewSubscribe()
 	subscribe1.Subscriptions = []packet.Subscription{{Topic: "overlap/#", QOS: 0}}
@@ -483,6 +764,156 @@ func TestServiceResubscribeTimeout(t *testing.T) {
 	assert.Equal(t, 2, i)
 }
 
+func TestServiceSubscriptions(t *testing.T) {
+	subscribe1 := packet.NewSubscribe()
+	subscribe1.Subscriptions = []packet.Subscription{
+		{Topic: "a", QOS: 1},
+		{Topic: "b", QOS: 1},
+	}
+	subscribe1.ID = 1
+
+	suback1 := packet.NewSuback()
+	suback1.ReturnCodes = []packet.QOS{0, packet.QOSFailure}
+	suback1.ID = 1
+
+	subscribe2 := packet.NewSubscribe()
+	subscribe2.Subscriptions = []packet.Subscription{{Topic: "b", QOS: 1}}
+	subscribe2.ID = 1
+
+	suback2 := packet.NewSuback()
+	suback2.ReturnCodes = []packet.QOS{1}
+	suback2.ID = 1
+
+	broker1 := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe1).
+		Send(suback1).
+		Close()
+
+	broker2 := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe2).
+		Send(suback2).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker1, broker2)
+
+	online1 := make(chan struct{})
+	online2 := make(chan struct{})
+
+	s := NewService()
+	s.ResubscribeAllSubscriptions = false
+
+	i := 0
+
+	s.OnlineCallback = func(_ bool) {
+		i++
+		if i == 1 {
+			close(online1)
+		} else if i == 2 {
+			close(online2)
+		}
+	}
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.ValidateSubs = false
+
+	s.Start(config)
+
+	safeReceive(online1)
+
+	sf := s.SubscribeMultiple(subscribe1.Subscriptions)
+	assert.Equal(t, []Subscription{
+		{Subscription: packet.Subscription{Topic: "a", QOS: 1}, State: SubscriptionPending},
+		{Subscription: packet.Subscription{Topic: "b", QOS: 1}, State: SubscriptionPending},
+	}, s.Subscriptions())
+
+	assert.NoError(t, sf.Wait(time.Second))
+	assert.Equal(t, []packet.QOS{0, packet.QOSFailure}, sf.ReturnCodes())
+	assert.Equal(t, []Subscription{
+		{Subscription: packet.Subscription{Topic: "a", QOS: 1}, State: SubscriptionActive, GrantedQOS: 0},
+		{Subscription: packet.Subscription{Topic: "b", QOS: 1}, State: SubscriptionFailed},
+	}, s.Subscriptions())
+
+	safeReceive(online2)
+
+	assert.Equal(t, []Subscription{
+		{Subscription: packet.Subscription{Topic: "a", QOS: 1}, State: SubscriptionActive, GrantedQOS: 0},
+		{Subscription: packet.Subscription{Topic: "b", QOS: 1}, State: SubscriptionActive, GrantedQOS: 1},
+	}, s.Subscriptions())
+
+	s.Stop(true)
+
+	safeReceive(done)
+
+	assert.Equal(t, 2, i)
+}
+
+func TestServiceSubscriptionsPending(t *testing.T) {
+	subscribe := packet.NewSubscribe()
+	subscribe.Subscriptions = []packet.Subscription{{Topic: "test", QOS: 1}}
+	subscribe.ID = 1
+
+	suback := packet.NewSuback()
+	suback.ReturnCodes = []packet.QOS{1}
+	suback.ID = 1
+
+	broker1 := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe).
+		Close()
+
+	broker2 := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe).
+		Send(suback).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker1, broker2)
+
+	online1 := make(chan struct{})
+	online2 := make(chan struct{})
+
+	s := NewService()
+	s.MinReconnectDelay = 10 * time.Millisecond
+	s.ResubscribeAllSubscriptions = false
+
+	i := 0
+
+	s.OnlineCallback = func(_ bool) {
+		i++
+		if i == 1 {
+			close(online1)
+		} else if i == 2 {
+			close(online2)
+		}
+	}
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	safeReceive(online1)
+
+	s.SubscribeMultiple(subscribe.Subscriptions)
+
+	safeReceive(online2)
+
+	assert.Equal(t, []Subscription{
+		{Subscription: packet.Subscription{Topic: "test", QOS: 1}, State: SubscriptionActive, GrantedQOS: 1},
+	}, s.Subscriptions())
+
+	s.Stop(true)
+
+	safeReceive(done)
+
+	assert.Equal(t, 2, i)
+}
+
 func TestServiceFutureSurvival(t *testing.T) {
 	connect := connectPacket()
 	connect.ClientID = "test"
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// A SlogLogger emits the log messages of a Client or Service as structured
+// log/slog records. Its Log method can be assigned to the Logger field and its
+// LogPacket method to the PacketLogger field. Sent and received packets are
+// logged with slog.LevelDebug, errors with slog.LevelError and all other
+// messages with slog.LevelInfo.
+type SlogLogger struct {
+	// The logger that receives the records.
+	Logger *slog.Logger
+
+	// Sampling can be set to only log every nth occurrence of a message, which
+	// is useful for high volume messages like "Sent" and "Received".
+	Sampling map[string]int
+
+	counters map[string]int
+	mutex    sync.Mutex
+}
+
+// NewSlogLogger returns a new SlogLogger that uses the specified logger.
+func NewSlogLogger(logger *slog.Logger) *SlogLogger {
+	return &SlogLogger{
+		Logger: logger,
+	}
+}
+
+// Log will emit a record for the supplied log message.
+func (l *SlogLogger) Log(msg string) {
+	// split message and detail
+	detail := ""
+	if i := strings.Index(msg, ": "); i >= 0 {
+		msg, detail = msg[:i], msg[i+2:]
+	}
+
+	// map messages
+	level := slog.LevelInfo
+	key := "detail"
+	switch {
+	case msg == "Received" || msg == "Sent":
+		level = slog.LevelDebug
+		key = "packet"
+	case strings.HasSuffix(msg, " Error"):
+		level = slog.LevelError
+		key = "error"
+	}
+
+	// check if enabled and sampled
+	if !l.Logger.Enabled(context.Background(), level) || !l.sample(msg) {
+		return
+	}
+
+	// log message
+	if detail != "" {
+		l.Logger.LogAttrs(context.Background(), level, msg, slog.String(key, detail))
+	} else {
+		l.Logger.LogAttrs(context.Background(), level, msg)
+	}
+}
+
+// LogPacket will emit a record for the supplied packet.
+func (l *SlogLogger) LogPacket(event string, clientID string, pkt packet.Generic) {
+	// check if enabled and sampled
+	if !l.Logger.Enabled(context.Background(), slog.LevelDebug) || !l.sample(event) {
+		return
+	}
+
+	// prepare attributes
+	attrs := []slog.Attr{
+		slog.String("client_id", clientID),
+		slog.String("packet_type", pkt.Type().String()),
+	}
+
+	// add packet id
+	if id, ok := packet.GetID(pkt); ok && id > 0 {
+		attrs = append(attrs, slog.Int("packet_id", int(id)))
+	}
+
+	// add message attributes
+	if publish, ok := pkt.(*packet.Publish); ok {
+		attrs = append(attrs,
+			slog.String("topic", publish.Message.Topic),
+			slog.Int("qos", int(publish.Message.QOS)),
+			slog.Int("payload_size", len(publish.Message.Payload)),
+		)
+	}
+
+	l.Logger.LogAttrs(context.Background(), slog.LevelDebug, event, attrs...)
+}
+
+func (l *SlogLogger) sample(msg string) bool {
+	// get rate
+	rate := l.Sampling[msg]
+	if rate <= 1 {
+		return true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// prepare counters
+	if l.counters == nil {
+		l.counters = make(map[string]int)
+	}
+
+	// get and increment counter
+	n := l.counters[msg]
+	l.counters[msg] = n + 1
+
+	return n%rate == 0
+}
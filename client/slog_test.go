@@ -0,0 +1,94 @@
+package client
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/transport/flow"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger := NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	})))
+
+	publish := packet.NewPublish()
+	publish.ID = 7
+	publish.Message = packet.Message{Topic: "foo", Payload: []byte("bar"), QOS: 1}
+
+	logger.Log("Sent: <Pingreq>")
+	logger.Log("Connect Error: failed")
+	logger.Log("Next Reconnect")
+	logger.LogPacket("Received", "test", publish)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 4)
+	assert.Contains(t, lines[0], `level=DEBUG msg=Sent packet=<Pingreq>`)
+	assert.Contains(t, lines[1], `level=ERROR msg="Connect Error" error=failed`)
+	assert.Contains(t, lines[2], `level=INFO msg="Next Reconnect"`)
+	assert.Contains(t, lines[3], `level=DEBUG msg=Received client_id=test packet_type=Publish packet_id=7 topic=foo qos=1 payload_size=3`)
+}
+
+func TestSlogLoggerSampling(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger := NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	})))
+	logger.Sampling = map[string]int{
+		"Sent": 10,
+	}
+
+	for i := 0; i < 25; i++ {
+		logger.LogPacket("Sent", "test", packet.NewPingreq())
+	}
+
+	logger.Log("Next Reconnect")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 4)
+	assert.Contains(t, lines[3], `level=INFO msg="Next Reconnect"`)
+}
+
+func TestClientSlogLogger(t *testing.T) {
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	var buf bytes.Buffer
+
+	logger := NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	})))
+
+	c := New()
+	c.Callback = errorCallback(t)
+	c.PacketLogger = logger.LogPacket
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.Contains(t, lines[0], `msg=Sent client_id="" packet_type=Connect`)
+	assert.Contains(t, lines[1], `msg=Received client_id="" packet_type=Connack`)
+	assert.Contains(t, lines[2], `msg=Sent client_id="" packet_type=Disconnect`)
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"sync/atomic"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// Statistics contains counters about the traffic of a client.
+type Statistics struct {
+	// The number of bytes sent and received.
+	BytesSent     uint64
+	BytesReceived uint64
+
+	// The number of publish packets sent and received.
+	MessagesSent     uint64
+	MessagesReceived uint64
+}
+
+// the counters are shared between all clients of a service
+type counters struct {
+	bytesSent        atomic.Uint64
+	bytesReceived    atomic.Uint64
+	messagesSent     atomic.Uint64
+	messagesReceived atomic.Uint64
+}
+
+// sent will count the sent packet
+func (c *counters) sent(pkt packet.Generic) {
+	c.bytesSent.Add(uint64(pkt.Len()))
+	if pkt.Type() == packet.PUBLISH {
+		c.messagesSent.Add(1)
+	}
+}
+
+// received will count the received packet
+func (c *counters) received(pkt packet.Generic) {
+	c.bytesReceived.Add(uint64(pkt.Len()))
+	if pkt.Type() == packet.PUBLISH {
+		c.messagesReceived.Add(1)
+	}
+}
+
+// statistics will return a snapshot of the counters
+func (c *counters) statistics() Statistics {
+	return Statistics{
+		BytesSent:        c.bytesSent.Load(),
+		BytesReceived:    c.bytesReceived.Load(),
+		MessagesSent:     c.messagesSent.Load(),
+		MessagesReceived: c.messagesReceived.Load(),
+	}
+}
+
+// Statistics will return the traffic counters of the client.
+func (c *Client) Statistics() Statistics {
+	return c.counters.statistics()
+}
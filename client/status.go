@@ -0,0 +1,150 @@
+package client
+
+import (
+	"time"
+)
+
+// ServiceState describes the connection state of a service.
+type ServiceState int
+
+const (
+	// ServiceStopped is the state of a service that has not been started or
+	// has been stopped.
+	ServiceStopped ServiceState = iota
+
+	// ServiceConnecting is the state of a service that is trying to connect
+	// or reconnect to the broker.
+	ServiceConnecting
+
+	// ServiceOnline is the state of a service that is connected to the broker.
+	ServiceOnline
+
+	// ServiceHalted is the state of a service that stopped reconnecting
+	// because the broker denied the connection.
+	ServiceHalted
+)
+
+// String returns the name of the state.
+func (s ServiceState) String() string {
+	switch s {
+	case ServiceStopped:
+		return "stopped"
+	case ServiceConnecting:
+		return "connecting"
+	case ServiceOnline:
+		return "online"
+	case ServiceHalted:
+		return "halted"
+	}
+
+	return "unknown"
+}
+
+// Status is a snapshot of the state of a service.
+type Status struct {
+	Statistics
+
+	// The current state of the service.
+	State ServiceState
+
+	// The broker URL of the last connection attempt.
+	BrokerURL string
+
+	// The time the current connection has been established. Zero if the
+	// service is not online.
+	ConnectedSince time.Time
+
+	// The number of connection attempts since the service has been started,
+	// not counting the first.
+	Reconnects int
+
+	// The last error that has been emitted.
+	LastError error
+
+	// The number of commands waiting to be sent.
+	QueuedCommands int
+
+	// The number of futures waiting for acknowledgements.
+	InflightFutures int
+
+	// The round trip time of the last ping of the current connection.
+	PingRTT time.Duration
+}
+
+// the status is updated by the supervisor
+type status struct {
+	state          ServiceState
+	brokerURL      string
+	connectedSince time.Time
+	reconnects     int
+	lastError      error
+	client         *Client
+	watchers       map[chan ServiceState]struct{}
+}
+
+// Status will return a snapshot of the state of the service.
+func (s *Service) Status() Status {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+
+	// prepare status
+	status := Status{
+		Statistics:      s.counters.statistics(),
+		State:           s.status.state,
+		BrokerURL:       s.status.brokerURL,
+		ConnectedSince:  s.status.connectedSince,
+		Reconnects:      s.status.reconnects,
+		LastError:       s.status.lastError,
+		QueuedCommands:  len(s.commandQueue),
+		InflightFutures: s.futureStore.Len(),
+	}
+
+	// get ping rtt
+	if s.status.client != nil {
+		status.PingRTT = s.status.client.tracker.RTT()
+	}
+
+	return status
+}
+
+// Watch will return a channel that receives the state of the service whenever
+// it changes and a function that stops the watching. State changes are
+// dropped if the channel is full. Use Status to get the current state.
+func (s *Service) Watch() (<-chan ServiceState, func()) {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+
+	// add watcher
+	ch := make(chan ServiceState, 16)
+	s.status.watchers[ch] = struct{}{}
+
+	return ch, func() {
+		s.statusMutex.Lock()
+		delete(s.status.watchers, ch)
+		s.statusMutex.Unlock()
+	}
+}
+
+// updates the status using the supplied function and notifies watchers if the
+// state has changed
+func (s *Service) update(fn func(status *status)) {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+
+	// update status
+	state := s.status.state
+	fn(&s.status)
+
+	// check state
+	if s.status.state == state {
+		return
+	}
+
+	// notify watchers
+	for ch := range s.status.watchers {
+		select {
+		case ch <- s.status.state:
+		default:
+		}
+	}
+}
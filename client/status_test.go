@@ -0,0 +1,105 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/transport/flow"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceStatus(t *testing.T) {
+	publish := packet.NewPublish()
+	publish.Message.Topic = "test"
+	publish.Message.Payload = []byte("test")
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(publish).
+		Send(publish).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	message := make(chan struct{})
+
+	s := NewService()
+
+	s.MessageCallback = func(msg *packet.Message) error {
+		close(message)
+		return nil
+	}
+
+	assert.Equal(t, ServiceStopped, s.Status().State)
+
+	states, stop := s.Watch()
+	defer stop()
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	assert.Equal(t, ServiceConnecting, <-states)
+	assert.Equal(t, ServiceOnline, <-states)
+
+	status := s.Status()
+	assert.Equal(t, ServiceOnline, status.State)
+	assert.Equal(t, "tcp://localhost:"+port, status.BrokerURL)
+	assert.False(t, status.ConnectedSince.IsZero())
+	assert.Equal(t, 0, status.Reconnects)
+	assert.NoError(t, status.LastError)
+
+	assert.NoError(t, s.Publish("test", []byte("test"), 0, false).Wait(1*time.Second))
+
+	safeReceive(message)
+
+	status = s.Status()
+	assert.Equal(t, uint64(1), status.MessagesSent)
+	assert.Equal(t, uint64(1), status.MessagesReceived)
+	assert.Equal(t, uint64(connectPacket().Len()+publish.Len()), status.BytesSent)
+	assert.Equal(t, uint64(connackPacket().Len()+publish.Len()), status.BytesReceived)
+	assert.Equal(t, 0, status.QueuedCommands)
+	assert.Equal(t, 0, status.InflightFutures)
+
+	s.Stop(true)
+
+	assert.Equal(t, ServiceStopped, <-states)
+	assert.Equal(t, ServiceStopped, s.Status().State)
+
+	safeReceive(done)
+}
+
+func TestServiceStatusHalted(t *testing.T) {
+	connack := connackPacket()
+	connack.ReturnCode = packet.NotAuthorized
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connack).
+		Close()
+
+	done, port := fakeBroker(t, broker)
+
+	s := NewService()
+	s.DenialActions[packet.NotAuthorized] = DenialStop
+
+	states, stop := s.Watch()
+	defer stop()
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	assert.Equal(t, ServiceConnecting, <-states)
+	assert.Equal(t, ServiceHalted, <-states)
+
+	safeReceive(done)
+
+	status := s.Status()
+	assert.Equal(t, ServiceHalted, status.State)
+	assert.Equal(t, &ConnectionDeniedError{Code: packet.NotAuthorized}, status.LastError)
+
+	s.Stop(true)
+
+	assert.Equal(t, ServiceStopped, <-states)
+}
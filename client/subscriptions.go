@@ -0,0 +1,127 @@
+package client
+
+import (
+	"sort"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// SubscriptionState describes the state of a subscription managed by a
+// service.
+type SubscriptionState int
+
+const (
+	// SubscriptionPending is the state of a subscription that has not yet
+	// been acknowledged by the broker. Pending subscriptions are retried on
+	// the next reconnect.
+	SubscriptionPending SubscriptionState = iota
+
+	// SubscriptionActive is the state of a subscription that has been granted
+	// by the broker.
+	SubscriptionActive
+
+	// SubscriptionFailed is the state of a subscription that has been
+	// rejected by the broker. Failed subscriptions are retried on the next
+	// reconnect.
+	SubscriptionFailed
+)
+
+// String returns the name of the state.
+func (s SubscriptionState) String() string {
+	switch s {
+	case SubscriptionPending:
+		return "pending"
+	case SubscriptionActive:
+		return "active"
+	case SubscriptionFailed:
+		return "failed"
+	}
+
+	return "unknown"
+}
+
+// A Subscription describes a subscription managed by a service.
+type Subscription struct {
+	packet.Subscription
+
+	// The current state of the subscription.
+	State SubscriptionState
+
+	// The QOS level granted by the broker. Only valid if the subscription is
+	// active.
+	GrantedQOS packet.QOS
+}
+
+// Subscriptions returns all subscriptions managed by the service sorted by
+// topic. Active subscriptions report the QOS level granted by the broker.
+func (s *Service) Subscriptions() []Subscription {
+	// get subscriptions
+	subs := s.subscriptions.All()
+
+	// sort subscriptions
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].Topic < subs[j].Topic
+	})
+
+	return subs
+}
+
+// returns the subscriptions that need to be sent after reconnecting and marks
+// them as pending
+func (s *Service) pendingSubscriptions() []packet.Subscription {
+	s.subscriptionsMutex.Lock()
+	defer s.subscriptionsMutex.Unlock()
+
+	// collect subscriptions
+	var list []packet.Subscription
+	for _, sub := range s.subscriptions.All() {
+		// skip active subscriptions if not all should be resubscribed
+		if !s.ResubscribeAllSubscriptions && sub.State == SubscriptionActive {
+			continue
+		}
+
+		// mark pending
+		sub.State = SubscriptionPending
+		s.subscriptions.Set(sub.Topic, sub)
+
+		list = append(list, sub.Subscription)
+	}
+
+	// sort subscriptions
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Topic < list[j].Topic
+	})
+
+	return list
+}
+
+// updates the state of the subscriptions using the return codes of the suback
+func (s *Service) acknowledge(list []packet.Subscription, codes []packet.QOS) {
+	s.subscriptionsMutex.Lock()
+	defer s.subscriptionsMutex.Unlock()
+
+	// check return codes
+	if len(codes) != len(list) {
+		return
+	}
+
+	for i, requested := range list {
+		// get subscription, skip if removed or changed in the meantime
+		subs := s.subscriptions.Get(requested.Topic)
+		if len(subs) == 0 || subs[0].Subscription != requested || subs[0].State != SubscriptionPending {
+			continue
+		}
+		sub := subs[0]
+
+		// update state
+		if codes[i] == packet.QOSFailure {
+			sub.State = SubscriptionFailed
+			sub.GrantedQOS = 0
+		} else {
+			sub.State = SubscriptionActive
+			sub.GrantedQOS = codes[i]
+		}
+
+		s.subscriptions.Set(sub.Topic, sub)
+	}
+}
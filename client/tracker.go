@@ -1,27 +1,64 @@
 package client
 
 import (
+	"math"
+	"sort"
 	"sync"
 	"time"
 )
 
+// the number of round trip times kept for statistics
+const trackerSamples = 64
+
+// RTTStatistics contains statistics about the round trip times of pings.
+type RTTStatistics struct {
+	// The number of recorded round trip times.
+	Samples int
+
+	// The last, minimum, average and 99th percentile round trip time.
+	Last time.Duration
+	Min  time.Duration
+	Avg  time.Duration
+	P99  time.Duration
+}
+
 // A Tracker keeps track of keep alive intervals.
+//
+// An adaptive tracker will shorten the interval between pings down to a
+// quarter of the timeout when round trip times spike and lengthen it again
+// once they are stable. It will also expect pongs within four times the 99th
+// percentile round trip time, bounded to a sixteenth and half of the timeout,
+// to detect half-open connections faster.
 type Tracker struct {
 	sync.RWMutex
 
-	last    time.Time
-	pings   uint8
-	timeout time.Duration
+	last     time.Time
+	pings    uint8
+	sent     time.Time
+	rtt      time.Duration
+	samples  []time.Duration
+	next     int
+	timeout  time.Duration
+	interval time.Duration
+	adaptive bool
 }
 
 // NewTracker returns a new tracker.
 func NewTracker(timeout time.Duration) *Tracker {
 	return &Tracker{
-		last:    time.Now(),
-		timeout: timeout,
+		last:     time.Now(),
+		timeout:  timeout,
+		interval: timeout,
 	}
 }
 
+// NewAdaptiveTracker returns a new adaptive tracker.
+func NewAdaptiveTracker(timeout time.Duration) *Tracker {
+	t := NewTracker(timeout)
+	t.adaptive = true
+	return t
+}
+
 // Reset will reset the tracker.
 func (t *Tracker) Reset() {
 	t.Lock()
@@ -35,7 +72,15 @@ func (t *Tracker) Window() time.Duration {
 	t.RLock()
 	defer t.RUnlock()
 
-	return t.timeout - time.Since(t.last)
+	return t.interval - time.Since(t.last)
+}
+
+// Interval returns the current interval between pings.
+func (t *Tracker) Interval() time.Duration {
+	t.RLock()
+	defer t.RUnlock()
+
+	return t.interval
 }
 
 // Ping marks a ping.
@@ -44,6 +89,7 @@ func (t *Tracker) Ping() {
 	defer t.Unlock()
 
 	t.pings++
+	t.sent = time.Now()
 }
 
 // Pong marks a pong.
@@ -51,7 +97,30 @@ func (t *Tracker) Pong() {
 	t.Lock()
 	defer t.Unlock()
 
+	// ignore unexpected pongs
+	if t.pings == 0 {
+		return
+	}
+
 	t.pings--
+	t.rtt = time.Since(t.sent)
+
+	// adapt interval
+	if t.adaptive && len(t.samples) > 0 {
+		if t.rtt > 2*t.average() {
+			t.interval = max(t.interval/2, t.timeout/4)
+		} else {
+			t.interval = min(t.interval+t.timeout/8, t.timeout)
+		}
+	}
+
+	// record sample
+	if len(t.samples) < trackerSamples {
+		t.samples = append(t.samples, t.rtt)
+	} else {
+		t.samples[t.next] = t.rtt
+		t.next = (t.next + 1) % trackerSamples
+	}
 }
 
 // Pending returns if pings are pending.
@@ -61,3 +130,78 @@ func (t *Tracker) Pending() bool {
 
 	return t.pings > 0
 }
+
+// PongWindow returns the time until the pong for a pending ping is overdue.
+func (t *Tracker) PongWindow() time.Duration {
+	t.RLock()
+	defer t.RUnlock()
+
+	// get pong timeout
+	timeout := t.timeout
+	if t.adaptive {
+		timeout = t.timeout / 2
+		if len(t.samples) > 0 {
+			timeout = max(min(4*t.percentile(0.99), timeout), t.timeout/16)
+		}
+	}
+
+	return timeout - time.Since(t.sent)
+}
+
+// RTT returns the round trip time of the last ping.
+func (t *Tracker) RTT() time.Duration {
+	t.RLock()
+	defer t.RUnlock()
+
+	return t.rtt
+}
+
+// RTTStatistics returns statistics about the recently recorded round trip
+// times.
+func (t *Tracker) RTTStatistics() RTTStatistics {
+	t.RLock()
+	defer t.RUnlock()
+
+	// check samples
+	if len(t.samples) == 0 {
+		return RTTStatistics{}
+	}
+
+	// get minimum
+	minimum := t.samples[0]
+	for _, sample := range t.samples {
+		minimum = min(minimum, sample)
+	}
+
+	return RTTStatistics{
+		Samples: len(t.samples),
+		Last:    t.rtt,
+		Min:     minimum,
+		Avg:     t.average(),
+		P99:     t.percentile(0.99),
+	}
+}
+
+func (t *Tracker) average() time.Duration {
+	var sum time.Duration
+	for _, sample := range t.samples {
+		sum += sample
+	}
+
+	return sum / time.Duration(len(t.samples))
+}
+
+func (t *Tracker) percentile(p float64) time.Duration {
+	// sort samples
+	sorted := make([]time.Duration, len(t.samples))
+	copy(sorted, t.samples)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	// get nearest rank
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	rank = max(0, min(rank, len(sorted)-1))
+
+	return sorted[rank]
+}
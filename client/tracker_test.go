@@ -21,6 +21,62 @@ func TestTracker(t *testing.T) {
 	tracker.Ping()
 	assert.True(t, tracker.Pending())
 
+	time.Sleep(5 * time.Millisecond)
+
 	tracker.Pong()
 	assert.False(t, tracker.Pending())
+	assert.True(t, tracker.RTT() >= 5*time.Millisecond)
+}
+
+func TestTrackerRTTStatistics(t *testing.T) {
+	tracker := NewTracker(time.Second)
+	assert.Equal(t, RTTStatistics{}, tracker.RTTStatistics())
+
+	for i := 1; i <= 100; i++ {
+		tracker.Ping()
+		tracker.sent = time.Now().Add(-time.Duration(i) * time.Millisecond)
+		tracker.Pong()
+	}
+
+	stats := tracker.RTTStatistics()
+	assert.Equal(t, trackerSamples, stats.Samples)
+	assert.True(t, stats.Last >= 100*time.Millisecond)
+	assert.True(t, stats.Min >= 37*time.Millisecond && stats.Min < 40*time.Millisecond)
+	assert.True(t, stats.Avg >= 68*time.Millisecond && stats.Avg < 71*time.Millisecond)
+	assert.True(t, stats.P99 >= 100*time.Millisecond)
+	assert.Equal(t, time.Second, tracker.Interval())
+}
+
+func TestAdaptiveTracker(t *testing.T) {
+	tracker := NewAdaptiveTracker(800 * time.Millisecond)
+	assert.Equal(t, 800*time.Millisecond, tracker.Interval())
+
+	tracker.Ping()
+	assert.True(t, tracker.PongWindow() <= 400*time.Millisecond)
+
+	tracker.sent = time.Now().Add(-10 * time.Millisecond)
+	tracker.Pong()
+	assert.Equal(t, 800*time.Millisecond, tracker.Interval())
+
+	tracker.Ping()
+	assert.True(t, tracker.PongWindow() <= 50*time.Millisecond)
+	assert.True(t, tracker.PongWindow() > 40*time.Millisecond)
+
+	tracker.sent = time.Now().Add(-50 * time.Millisecond)
+	tracker.Pong()
+	assert.Equal(t, 400*time.Millisecond, tracker.Interval())
+
+	tracker.Ping()
+	tracker.sent = time.Now().Add(-200 * time.Millisecond)
+	tracker.Pong()
+	assert.Equal(t, 200*time.Millisecond, tracker.Interval())
+
+	tracker.Ping()
+	tracker.sent = time.Now().Add(-10 * time.Millisecond)
+	tracker.Pong()
+	assert.Equal(t, 300*time.Millisecond, tracker.Interval())
+
+	tracker.Ping()
+	assert.True(t, tracker.PongWindow() <= 400*time.Millisecond)
+	assert.True(t, tracker.PongWindow() > 350*time.Millisecond)
 }
@@ -14,6 +14,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/256dpi/gomqtt/client"
 	"github.com/256dpi/gomqtt/packet"
 	"github.com/256dpi/gomqtt/transport"
 
@@ -27,6 +28,7 @@ var sendRate = flag.Int("send-rate", 0, "messages per second")
 var receiveRate = flag.Int("receive-rate", 0, "messages per second")
 var payloadSize = flag.Int("payload", 1, "payload size in bytes")
 var retained = flag.Bool("retained", false, "retain flag")
+var batch = flag.Int("batch", 0, "publish using the client in batches of the specified size (1 disables batching)")
 
 var sent int32
 var received int32
@@ -70,7 +72,12 @@ func main() {
 		id := strconv.Itoa(i)
 
 		go consumer(id)
-		go publisher(id)
+
+		if *batch > 0 {
+			go clientPublisher(id)
+		} else {
+			go publisher(id)
+		}
 	}
 
 	go reporter()
@@ -189,6 +196,56 @@ func publisher(id string) {
 	}
 }
 
+func clientPublisher(id string) {
+	name := "publisher/" + id
+
+	c := client.New()
+
+	cf, err := c.Connect(client.NewConfigWithClientID(*broker, "gomqtt-benchmark/"+name))
+	if err != nil {
+		panic(err)
+	}
+
+	err = cf.Wait(10 * time.Second)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Connected: %s\n", name)
+
+	msgs := make([]*packet.Message, *batch)
+	for i := range msgs {
+		msgs[i] = &packet.Message{
+			Topic:   id,
+			Payload: payload,
+			Retain:  *retained,
+		}
+	}
+
+	var bucket *ratelimit.Bucket
+	if *sendRate > 0 {
+		bucket = ratelimit.NewBucketWithRate(float64(*sendRate), int64(*sendRate))
+	}
+
+	for {
+		if bucket != nil {
+			bucket.Wait(int64(len(msgs)))
+		}
+
+		if len(msgs) == 1 {
+			_, err = c.PublishMessage(msgs[0])
+		} else {
+			_, err = c.PublishBatch(msgs)
+		}
+		if err != nil {
+			panic(err)
+		}
+
+		atomic.AddInt32(&sent, int32(len(msgs)))
+		atomic.AddInt32(&delta, int32(len(msgs)))
+	}
+}
+
 func reporter() {
 	var iterations int32
 
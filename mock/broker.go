@@ -0,0 +1,446 @@
+// Package mock implements an in-process broker to test client applications.
+package mock
+
+import (
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/topic"
+	"github.com/256dpi/gomqtt/transport"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A Broker is a minimal in-process broker that records received messages and
+// allows injecting messages and simulating failures. It supports subscriptions
+// and all quality of service levels, but does not support sessions, retained
+// messages and will messages.
+type Broker struct {
+	server transport.Server
+
+	denial   packet.ConnackCode
+	dropAcks bool
+	delay    time.Duration
+
+	conns     map[*conn]struct{}
+	messages  []*packet.Message
+	connects  []*packet.Connect
+	signal    chan struct{}
+	closed    bool
+	mutex     sync.Mutex
+	waitGroup sync.WaitGroup
+}
+
+type conn struct {
+	transport.Conn
+
+	subscriptions *topic.TypedTree[packet.QOS]
+	counter       packet.ID
+	mutex         sync.Mutex
+}
+
+// NewBroker will launch and return a new broker that listens on a random
+// local port.
+func NewBroker() *Broker {
+	// launch server
+	server, err := transport.Launch("tcp://localhost:0")
+	if err != nil {
+		panic(err)
+	}
+
+	// prepare broker
+	b := &Broker{
+		server: server,
+		conns:  make(map[*conn]struct{}),
+		signal: make(chan struct{}),
+	}
+
+	// accept connections
+	b.waitGroup.Add(1)
+	go b.acceptor()
+
+	return b
+}
+
+// URL returns the URL of the broker.
+func (b *Broker) URL() string {
+	return "tcp://" + b.server.Addr().String()
+}
+
+// Deny will deny subsequent connections with the specified return code. The
+// code packet.ConnectionAccepted will allow connections again.
+func (b *Broker) Deny(code packet.ConnackCode) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.denial = code
+}
+
+// DropAcks will configure the broker to not acknowledge subsequent subscribe,
+// unsubscribe and publish packets.
+func (b *Broker) DropAcks(drop bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.dropAcks = drop
+}
+
+// Delay will delay all subsequent responses by the specified duration.
+func (b *Broker) Delay(delay time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.delay = delay
+}
+
+// Disconnect will abruptly close all current connections.
+func (b *Broker) Disconnect() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for c := range b.conns {
+		_ = c.Close()
+	}
+}
+
+// Inject will deliver the message to all connections with a matching
+// subscription and return the number of receivers.
+func (b *Broker) Inject(msg *packet.Message) int {
+	b.mutex.Lock()
+	conns := make([]*conn, 0, len(b.conns))
+	for c := range b.conns {
+		conns = append(conns, c)
+	}
+	b.mutex.Unlock()
+
+	// deliver message
+	var receivers int
+	for _, c := range conns {
+		if b.deliver(c, msg) {
+			receivers++
+		}
+	}
+
+	return receivers
+}
+
+// Messages returns all messages that have been published by clients.
+func (b *Broker) Messages() []*packet.Message {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	messages := make([]*packet.Message, len(b.messages))
+	copy(messages, b.messages)
+
+	return messages
+}
+
+// Connects returns all connect packets that have been received.
+func (b *Broker) Connects() []*packet.Connect {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	connects := make([]*packet.Connect, len(b.connects))
+	copy(connects, b.connects)
+
+	return connects
+}
+
+// Connections returns the number of current connections.
+func (b *Broker) Connections() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return len(b.conns)
+}
+
+// Await will wait until a message with the specified topic has been published
+// by a client and return it. It will return nil if the timeout is reached.
+func (b *Broker) Await(topic string, timeout time.Duration) *packet.Message {
+	deadline := time.After(timeout)
+
+	for i := 0; ; {
+		b.mutex.Lock()
+		messages := b.messages
+		signal := b.signal
+		b.mutex.Unlock()
+
+		// check messages
+		for ; i < len(messages); i++ {
+			if messages[i].Topic == topic {
+				return messages[i]
+			}
+		}
+
+		// wait for next message
+		select {
+		case <-signal:
+		case <-deadline:
+			return nil
+		}
+	}
+}
+
+// AssertPublished asserts that a message with the specified topic and payload
+// is published by a client within the timeout.
+func (b *Broker) AssertPublished(t assert.TestingT, topic string, payload []byte, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	for i := 0; ; {
+		b.mutex.Lock()
+		messages := b.messages
+		signal := b.signal
+		b.mutex.Unlock()
+
+		// check messages
+		for ; i < len(messages); i++ {
+			if messages[i].Topic == topic && string(messages[i].Payload) == string(payload) {
+				return true
+			}
+		}
+
+		// wait for next message
+		select {
+		case <-signal:
+		case <-time.After(time.Until(deadline)):
+			return assert.Fail(t, "message not published", "topic: %s, payload: %q", topic, payload)
+		}
+	}
+}
+
+// AssertNotPublished asserts that no message with the specified topic has been
+// published by a client.
+func (b *Broker) AssertNotPublished(t assert.TestingT, topic string) bool {
+	for _, msg := range b.Messages() {
+		if msg.Topic == topic {
+			return assert.Fail(t, "message published", "topic: %s", topic)
+		}
+	}
+
+	return true
+}
+
+// AssertConnected asserts that the specified number of clients is connected.
+func (b *Broker) AssertConnected(t assert.TestingT, connections int) bool {
+	return assert.Equal(t, connections, b.Connections(), "connections")
+}
+
+// Reset will forget all recorded messages and connect packets and reset all
+// simulated failures.
+func (b *Broker) Reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.denial = packet.ConnectionAccepted
+	b.dropAcks = false
+	b.delay = 0
+	b.messages = nil
+	b.connects = nil
+}
+
+// Close will close the broker and all current connections.
+func (b *Broker) Close() {
+	b.mutex.Lock()
+	b.closed = true
+	b.mutex.Unlock()
+
+	// close server and connections
+	_ = b.server.Close()
+	b.Disconnect()
+
+	b.waitGroup.Wait()
+}
+
+func (b *Broker) acceptor() {
+	defer b.waitGroup.Done()
+
+	for {
+		// accept next connection
+		tc, err := b.server.Accept()
+		if err != nil {
+			return
+		}
+
+		// prepare connection
+		c := &conn{
+			Conn:          tc,
+			subscriptions: topic.NewTypedTree[packet.QOS](nil),
+		}
+
+		// add connection
+		b.mutex.Lock()
+		if b.closed {
+			b.mutex.Unlock()
+			_ = tc.Close()
+			return
+		}
+		b.conns[c] = struct{}{}
+		b.mutex.Unlock()
+
+		// handle connection
+		b.waitGroup.Add(1)
+		go b.handler(c)
+	}
+}
+
+func (b *Broker) handler(c *conn) {
+	defer b.waitGroup.Done()
+
+	// ensure connection is removed and closed
+	defer func() {
+		b.mutex.Lock()
+		delete(b.conns, c)
+		b.mutex.Unlock()
+
+		_ = c.Close()
+	}()
+
+	// receive connect packet
+	pkt, err := c.Receive()
+	if err != nil {
+		return
+	}
+
+	// check packet
+	connect, ok := pkt.(*packet.Connect)
+	if !ok {
+		return
+	}
+
+	// record connect
+	b.mutex.Lock()
+	b.connects = append(b.connects, connect)
+	denial := b.denial
+	b.mutex.Unlock()
+
+	// prepare connack
+	connack := packet.NewConnack()
+	connack.ReturnCode = denial
+
+	// send connack
+	b.respond(c, connack, false)
+	if denial != packet.ConnectionAccepted {
+		return
+	}
+
+	for {
+		// receive next packet
+		pkt, err := c.Receive()
+		if err != nil {
+			return
+		}
+
+		// handle packet
+		switch pkt := pkt.(type) {
+		case *packet.Subscribe:
+			// add subscriptions
+			suback := packet.NewSuback()
+			suback.ID = pkt.ID
+			for _, sub := range pkt.Subscriptions {
+				c.subscriptions.Set(sub.Topic, sub.QOS)
+				suback.ReturnCodes = append(suback.ReturnCodes, sub.QOS)
+			}
+
+			b.respond(c, suback, true)
+		case *packet.Unsubscribe:
+			// remove subscriptions
+			for _, topic := range pkt.Topics {
+				c.subscriptions.Empty(topic)
+			}
+
+			unsuback := packet.NewUnsuback()
+			unsuback.ID = pkt.ID
+			b.respond(c, unsuback, true)
+		case *packet.Publish:
+			// record message
+			msg := pkt.Message.Copy()
+			b.mutex.Lock()
+			b.messages = append(b.messages, msg)
+			close(b.signal)
+			b.signal = make(chan struct{})
+			b.mutex.Unlock()
+
+			// acknowledge message
+			switch msg.QOS {
+			case 1:
+				puback := packet.NewPuback()
+				puback.ID = pkt.ID
+				b.respond(c, puback, true)
+			case 2:
+				pubrec := packet.NewPubrec()
+				pubrec.ID = pkt.ID
+				b.respond(c, pubrec, true)
+			}
+
+			// forward message
+			b.Inject(msg)
+		case *packet.Pubrel:
+			pubcomp := packet.NewPubcomp()
+			pubcomp.ID = pkt.ID
+			b.respond(c, pubcomp, true)
+		case *packet.Pubrec:
+			pubrel := packet.NewPubrel()
+			pubrel.ID = pkt.ID
+			b.respond(c, pubrel, false)
+		case *packet.Pingreq:
+			b.respond(c, packet.NewPingresp(), false)
+		case *packet.Disconnect:
+			return
+		}
+	}
+}
+
+// sends the response after the configured delay unless acks are dropped
+func (b *Broker) respond(c *conn, pkt packet.Generic, ack bool) {
+	b.mutex.Lock()
+	delay := b.delay
+	drop := b.dropAcks && ack
+	b.mutex.Unlock()
+
+	// check drop
+	if drop {
+		return
+	}
+
+	// delay response
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	_ = c.Send(pkt, false)
+}
+
+// delivers the message if the connection has a matching subscription
+func (b *Broker) deliver(c *conn, msg *packet.Message) bool {
+	// get maximum granted qos
+	qosLevels := c.subscriptions.Match(msg.Topic)
+	if len(qosLevels) == 0 {
+		return false
+	}
+	qos := qosLevels[0]
+	for _, q := range qosLevels {
+		qos = max(qos, q)
+	}
+
+	// prepare publish
+	publish := packet.NewPublish()
+	publish.Message = *msg
+	publish.Message.QOS = min(msg.QOS, qos)
+
+	// set id
+	if publish.Message.QOS > 0 {
+		c.mutex.Lock()
+		c.counter++
+		if c.counter == 0 {
+			c.counter = 1
+		}
+		publish.ID = c.counter
+		c.mutex.Unlock()
+	}
+
+	// send publish
+	_ = c.Send(publish, false)
+
+	return true
+}
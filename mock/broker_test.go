@@ -0,0 +1,219 @@
+package mock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/client/future"
+	"github.com/256dpi/gomqtt/packet"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrokerPublish(t *testing.T) {
+	broker := NewBroker()
+	defer broker.Close()
+
+	c := client.New()
+
+	cf, err := c.Connect(client.NewConfig(broker.URL()))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(time.Second))
+
+	for _, qos := range []packet.QOS{0, 1, 2} {
+		pf, err := c.Publish("test", []byte{byte(qos)}, qos, false)
+		assert.NoError(t, err)
+		assert.NoError(t, pf.Wait(time.Second))
+	}
+
+	broker.AssertPublished(t, "test", []byte{0}, time.Second)
+	broker.AssertPublished(t, "test", []byte{1}, time.Second)
+	broker.AssertPublished(t, "test", []byte{2}, time.Second)
+	broker.AssertNotPublished(t, "foo")
+	broker.AssertConnected(t, 1)
+
+	assert.Len(t, broker.Messages(), 3)
+	assert.Len(t, broker.Connects(), 1)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+}
+
+func TestBrokerAwait(t *testing.T) {
+	broker := NewBroker()
+	defer broker.Close()
+
+	c := client.New()
+
+	cf, err := c.Connect(client.NewConfig(broker.URL()))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(time.Second))
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_, _ = c.Publish("test", []byte("test"), 0, false)
+	}()
+
+	msg := broker.Await("test", time.Second)
+	assert.NotNil(t, msg)
+	assert.Equal(t, []byte("test"), msg.Payload)
+
+	msg = broker.Await("foo", 10*time.Millisecond)
+	assert.Nil(t, msg)
+
+	broker.Reset()
+	assert.Empty(t, broker.Messages())
+	assert.Empty(t, broker.Connects())
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+}
+
+func TestBrokerInject(t *testing.T) {
+	broker := NewBroker()
+	defer broker.Close()
+
+	done := make(chan struct{})
+
+	c := client.New()
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.NoError(t, err)
+		assert.Equal(t, "foo/bar", msg.Topic)
+		assert.Equal(t, []byte("test"), msg.Payload)
+		assert.Equal(t, packet.QOS(1), msg.QOS)
+		close(done)
+		return nil
+	}
+
+	cf, err := c.Connect(client.NewConfig(broker.URL()))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(time.Second))
+
+	sf, err := c.Subscribe("foo/+", 1)
+	assert.NoError(t, err)
+	assert.NoError(t, sf.Wait(time.Second))
+	assert.Equal(t, []packet.QOS{1}, sf.ReturnCodes())
+
+	n := broker.Inject(&packet.Message{
+		Topic:   "foo/bar",
+		Payload: []byte("test"),
+		QOS:     2,
+	})
+	assert.Equal(t, 1, n)
+
+	safeReceive(done)
+
+	n = broker.Inject(&packet.Message{
+		Topic:   "bar",
+		Payload: []byte("test"),
+	})
+	assert.Equal(t, 0, n)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+}
+
+func TestBrokerDeny(t *testing.T) {
+	broker := NewBroker()
+	defer broker.Close()
+
+	broker.Deny(packet.NotAuthorized)
+
+	c := client.New()
+
+	cf, err := c.Connect(client.NewConfig(broker.URL()))
+	assert.NoError(t, err)
+	assert.Equal(t, future.ErrCanceled, cf.Wait(time.Second))
+	assert.Equal(t, packet.NotAuthorized, cf.ReturnCode())
+
+	broker.Deny(packet.ConnectionAccepted)
+
+	c = client.New()
+
+	cf, err = c.Connect(client.NewConfig(broker.URL()))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(time.Second))
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+}
+
+func TestBrokerDropAcks(t *testing.T) {
+	broker := NewBroker()
+	defer broker.Close()
+
+	broker.DropAcks(true)
+
+	c := client.New()
+
+	cf, err := c.Connect(client.NewConfig(broker.URL()))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(time.Second))
+
+	pf, err := c.Publish("test", []byte("test"), 1, false)
+	assert.NoError(t, err)
+	assert.Equal(t, future.ErrTimeout, pf.Wait(50*time.Millisecond))
+
+	broker.AssertPublished(t, "test", []byte("test"), time.Second)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+}
+
+func TestBrokerDelay(t *testing.T) {
+	broker := NewBroker()
+	defer broker.Close()
+
+	broker.Delay(50 * time.Millisecond)
+
+	c := client.New()
+
+	start := time.Now()
+
+	cf, err := c.Connect(client.NewConfig(broker.URL()))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(time.Second))
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+}
+
+func TestBrokerDisconnect(t *testing.T) {
+	broker := NewBroker()
+	defer broker.Close()
+
+	online := make(chan struct{}, 2)
+	offline := make(chan struct{}, 1)
+
+	s := client.NewService()
+	s.MinReconnectDelay = 10 * time.Millisecond
+	s.OnlineCallback = func(bool) {
+		online <- struct{}{}
+	}
+	s.OfflineCallback = func() {
+		offline <- struct{}{}
+	}
+
+	s.Start(client.NewConfig(broker.URL()))
+
+	safeReceive(online)
+	broker.AssertConnected(t, 1)
+
+	broker.Disconnect()
+
+	safeReceive(offline)
+	safeReceive(online)
+	assert.Len(t, broker.Connects(), 2)
+
+	s.Stop(true)
+}
+
+func safeReceive(ch <-chan struct{}) {
+	select {
+	case <-time.After(time.Second):
+		panic("nothing received")
+	case <-ch:
+	}
+}
@@ -0,0 +1,158 @@
+package packet
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// DefaultDecompressionLimit is the maximum size of a decompressed payload that
+// is used if no limit has been configured.
+const DefaultDecompressionLimit = 64 << 20
+
+// ErrDecompressionLimitExceeded is returned by Compression.Decompress if the
+// decompressed payload exceeds the limit.
+var ErrDecompressionLimitExceeded = errors.New("decompression limit exceeded")
+
+// A Compressor compresses and decompresses message payloads.
+type Compressor interface {
+	// NewWriter should return a writer that compresses data to the supplied
+	// writer.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+
+	// NewReader should return a reader that decompresses data from the
+	// supplied reader.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// GzipCompressor compresses payloads using the compress/gzip package.
+type GzipCompressor struct{}
+
+// NewWriter will return a new gzip writer.
+func (GzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+// NewReader will return a new gzip reader.
+func (GzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// DeflateCompressor compresses payloads using the compress/flate package.
+type DeflateCompressor struct{}
+
+// NewWriter will return a new deflate writer.
+func (DeflateCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return flate.NewWriter(w, flate.DefaultCompression)
+}
+
+// NewReader will return a new deflate reader.
+func (DeflateCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
+
+// Compression defines which message payloads are compressed. Payloads of
+// messages with a topic that starts with the prefix are compressed, which
+// allows publishers and subscribers to agree on compressed topics.
+type Compression struct {
+	// The compressor used to compress and decompress payloads.
+	Compressor Compressor
+
+	// The prefix of topics that carry compressed payloads. All messages are
+	// compressed if the prefix is empty.
+	Prefix string
+
+	// The maximum size of a decompressed payload. The limit should correspond
+	// to the read limit of the connection (Decoder.Limit) to protect against
+	// payloads that expand to excessive sizes.
+	//
+	// Will default to DefaultDecompressionLimit if zero.
+	Limit int64
+}
+
+// Applies returns whether the payload of the supplied message is compressed.
+func (c *Compression) Applies(msg *Message) bool {
+	return strings.HasPrefix(msg.Topic, c.Prefix)
+}
+
+// Compress will return a copy of the message with a compressed payload if the
+// message topic matches the prefix. Otherwise the message is returned as is.
+// Empty payloads are never compressed to allow clearing retained messages.
+func (c *Compression) Compress(msg *Message) (*Message, error) {
+	// check topic and payload
+	if !c.Applies(msg) || len(msg.Payload) == 0 {
+		return msg, nil
+	}
+
+	// prepare writer
+	var buf bytes.Buffer
+	writer, err := c.Compressor.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	// compress payload
+	_, err = writer.Write(msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// flush writer
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// copy message
+	msg = msg.Copy()
+	msg.Payload = buf.Bytes()
+
+	return msg, nil
+}
+
+// Decompress will return a copy of the message with a decompressed payload if
+// the message topic matches the prefix. Otherwise the message is returned as
+// is. Empty payloads are returned as is as well. ErrDecompressionLimitExceeded
+// is returned if the decompressed payload exceeds the limit.
+func (c *Compression) Decompress(msg *Message) (*Message, error) {
+	// check topic and payload
+	if !c.Applies(msg) || len(msg.Payload) == 0 {
+		return msg, nil
+	}
+
+	// get limit
+	limit := c.Limit
+	if limit <= 0 {
+		limit = DefaultDecompressionLimit
+	}
+
+	// prepare reader
+	reader, err := c.Compressor.NewReader(bytes.NewReader(msg.Payload))
+	if err != nil {
+		return nil, err
+	}
+
+	// ensure reader is closed
+	defer reader.Close()
+
+	// decompress payload while reading at most one byte more than allowed
+	payload, err := ioutil.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	// check limit
+	if int64(len(payload)) > limit {
+		return nil, ErrDecompressionLimitExceeded
+	}
+
+	// copy message
+	msg = msg.Copy()
+	msg.Payload = payload
+
+	return msg, nil
+}
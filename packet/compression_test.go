@@ -0,0 +1,108 @@
+package packet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompression(t *testing.T) {
+	payload := bytes.Repeat([]byte("test"), 1000)
+
+	for _, compressor := range []Compressor{GzipCompressor{}, DeflateCompressor{}} {
+		compression := &Compression{
+			Compressor: compressor,
+			Prefix:     "z/",
+		}
+
+		msg := &Message{
+			Topic:   "z/foo",
+			Payload: payload,
+			QOS:     1,
+		}
+
+		compressed, err := compression.Compress(msg)
+		assert.NoError(t, err)
+		assert.True(t, len(compressed.Payload) < len(payload))
+		assert.Equal(t, payload, msg.Payload)
+		assert.Equal(t, "z/foo", compressed.Topic)
+		assert.Equal(t, QOS(1), compressed.QOS)
+
+		decompressed, err := compression.Decompress(compressed)
+		assert.NoError(t, err)
+		assert.Equal(t, msg, decompressed)
+	}
+}
+
+func TestCompressionPrefix(t *testing.T) {
+	compression := &Compression{
+		Compressor: GzipCompressor{},
+		Prefix:     "z/",
+	}
+
+	msg := &Message{
+		Topic:   "foo",
+		Payload: []byte("test"),
+	}
+
+	compressed, err := compression.Compress(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, msg, compressed)
+
+	decompressed, err := compression.Decompress(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, msg, decompressed)
+}
+
+func TestCompressionLimit(t *testing.T) {
+	compression := &Compression{
+		Compressor: GzipCompressor{},
+		Limit:      100,
+	}
+
+	msg, err := compression.Compress(&Message{
+		Topic:   "foo",
+		Payload: make([]byte, 101),
+	})
+	assert.NoError(t, err)
+
+	_, err = compression.Decompress(msg)
+	assert.Equal(t, ErrDecompressionLimitExceeded, err)
+
+	msg, err = compression.Compress(&Message{
+		Topic:   "foo",
+		Payload: make([]byte, 100),
+	})
+	assert.NoError(t, err)
+
+	msg, err = compression.Decompress(msg)
+	assert.NoError(t, err)
+	assert.Len(t, msg.Payload, 100)
+
+	_, err = compression.Decompress(&Message{
+		Topic:   "foo",
+		Payload: []byte("invalid"),
+	})
+	assert.Error(t, err)
+}
+
+func TestCompressionEmptyPayload(t *testing.T) {
+	compression := &Compression{
+		Compressor: GzipCompressor{},
+	}
+
+	msg := &Message{
+		Topic:  "foo",
+		Retain: true,
+	}
+
+	compressed, err := compression.Compress(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, msg, compressed)
+	assert.Empty(t, compressed.Payload)
+
+	decompressed, err := compression.Decompress(compressed)
+	assert.NoError(t, err)
+	assert.Equal(t, msg, decompressed)
+}
@@ -1,11 +1,16 @@
 package session
 
 import (
+	"errors"
+	"math"
 	"sync"
 
 	"github.com/256dpi/gomqtt/packet"
 )
 
+// ErrNoFreeID is returned if all packet ids are in use.
+var ErrNoFreeID = errors.New("no free packet id")
+
 // An IDCounter continuously counts packet ids.
 type IDCounter struct {
 	next  packet.ID
@@ -30,6 +35,27 @@ func (c *IDCounter) NextID() packet.ID {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
+	return c.increment()
+}
+
+// NextFreeID will return the next id that is not reported as used by the
+// supplied function. ErrNoFreeID is returned if all ids are in use.
+func (c *IDCounter) NextFreeID(used func(packet.ID) bool) (packet.ID, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// try all ids once
+	for i := 0; i < math.MaxUint16; i++ {
+		id := c.increment()
+		if !used(id) {
+			return id, nil
+		}
+	}
+
+	return 0, ErrNoFreeID
+}
+
+func (c *IDCounter) increment() packet.ID {
 	// ignore zeroes
 	if c.next == 0 {
 		c.next++
@@ -30,3 +30,19 @@ func TestIDCounter(t *testing.T) {
 
 	assert.Equal(t, packet.ID(10), counter.NextID())
 }
+
+func TestIDCounterNextFreeID(t *testing.T) {
+	counter := NewIDCounterWithNext(math.MaxUint16)
+
+	id, err := counter.NextFreeID(func(id packet.ID) bool {
+		return id == math.MaxUint16 || id == 1
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, packet.ID(2), id)
+
+	id, err = counter.NextFreeID(func(id packet.ID) bool {
+		return true
+	})
+	assert.Equal(t, ErrNoFreeID, err)
+	assert.Equal(t, packet.ID(0), id)
+}
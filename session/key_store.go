@@ -0,0 +1,58 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// KeyStore is a goroutine safe store for keys and the time they have been saved.
+type KeyStore struct {
+	keys  map[string]time.Time
+	mutex sync.RWMutex
+}
+
+// NewKeyStore returns a new KeyStore.
+func NewKeyStore() *KeyStore {
+	return &KeyStore{
+		keys: make(map[string]time.Time),
+	}
+}
+
+// Save will store a key in the store. An eventual existing key gets quietly
+// overwritten.
+func (s *KeyStore) Save(key string, saved time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.keys[key] = saved
+}
+
+// Delete will remove a key from the store.
+func (s *KeyStore) Delete(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.keys, key)
+}
+
+// All will return all keys currently saved in the store.
+func (s *KeyStore) All() map[string]time.Time {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	all := make(map[string]time.Time, len(s.keys))
+
+	for key, saved := range s.keys {
+		all[key] = saved
+	}
+
+	return all
+}
+
+// Reset will reset the store.
+func (s *KeyStore) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.keys = make(map[string]time.Time)
+}
@@ -0,0 +1,25 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyStore(t *testing.T) {
+	store := NewKeyStore()
+	assert.Empty(t, store.All())
+
+	now := time.Now()
+
+	store.Save("foo", now)
+	store.Save("bar", now)
+	assert.Equal(t, map[string]time.Time{"foo": now, "bar": now}, store.All())
+
+	store.Delete("foo")
+	assert.Equal(t, map[string]time.Time{"bar": now}, store.All())
+
+	store.Reset()
+	assert.Empty(t, store.All())
+}
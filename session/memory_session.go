@@ -3,6 +3,8 @@
 package session
 
 import (
+	"time"
+
 	"github.com/256dpi/gomqtt/packet"
 )
 
@@ -17,11 +19,12 @@ const (
 	Outgoing
 )
 
-// A MemorySession stores packets in memory.
+// A MemorySession stores packets and keys in memory.
 type MemorySession struct {
 	Counter  *IDCounter
 	Incoming *PacketStore
 	Outgoing *PacketStore
+	Keys     *KeyStore
 }
 
 // NewMemorySession returns a new MemorySession.
@@ -30,12 +33,17 @@ func NewMemorySession() *MemorySession {
 		Counter:  NewIDCounter(),
 		Incoming: NewPacketStore(),
 		Outgoing: NewPacketStore(),
+		Keys:     NewKeyStore(),
 	}
 }
 
-// NextID will return the next id for outgoing packets.
-func (s *MemorySession) NextID() packet.ID {
-	return s.Counter.NextID()
+// NextID will return the next id for outgoing packets. Ids of outgoing packets
+// that are still stored in the session are skipped. ErrNoFreeID is returned if
+// all ids are in use.
+func (s *MemorySession) NextID() (packet.ID, error) {
+	return s.Counter.NextFreeID(func(id packet.ID) bool {
+		return s.Outgoing.Lookup(id) != nil
+	})
 }
 
 // SavePacket will store a packet in the session. An eventual existing
@@ -62,12 +70,30 @@ func (s *MemorySession) AllPackets(dir Direction) ([]packet.Generic, error) {
 	return s.storeForDirection(dir).All(), nil
 }
 
+// SaveKey will store a key in the session.
+func (s *MemorySession) SaveKey(key string, saved time.Time) error {
+	s.Keys.Save(key, saved)
+	return nil
+}
+
+// DeleteKey will remove a key from the session.
+func (s *MemorySession) DeleteKey(key string) error {
+	s.Keys.Delete(key)
+	return nil
+}
+
+// AllKeys will return all keys currently saved in the session.
+func (s *MemorySession) AllKeys() (map[string]time.Time, error) {
+	return s.Keys.All(), nil
+}
+
 // Reset will completely reset the session.
 func (s *MemorySession) Reset() error {
 	// reset counter and stores
 	s.Counter.Reset()
 	s.Incoming.Reset()
 	s.Outgoing.Reset()
+	s.Keys.Reset()
 
 	return nil
 }
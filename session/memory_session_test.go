@@ -3,6 +3,7 @@ package session
 import (
 	"math"
 	"testing"
+	"time"
 
 	"github.com/256dpi/gomqtt/packet"
 
@@ -12,20 +13,72 @@ import (
 func TestMemorySessionNextID(t *testing.T) {
 	session := NewMemorySession()
 
-	assert.Equal(t, packet.ID(1), session.NextID())
-	assert.Equal(t, packet.ID(2), session.NextID())
+	id, err := session.NextID()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.ID(1), id)
+
+	id, err = session.NextID()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.ID(2), id)
 
 	for i := 0; i < math.MaxUint16-3; i++ {
 		session.NextID()
 	}
 
-	assert.Equal(t, packet.ID(math.MaxUint16), session.NextID())
-	assert.Equal(t, packet.ID(1), session.NextID())
+	id, err = session.NextID()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.ID(math.MaxUint16), id)
+
+	id, err = session.NextID()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.ID(1), id)
+
+	err = session.Reset()
+	assert.NoError(t, err)
+
+	id, err = session.NextID()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.ID(1), id)
+}
+
+func TestMemorySessionNextIDInFlight(t *testing.T) {
+	session := NewMemorySession()
+
+	publish := packet.NewPublish()
+	publish.ID = 2
+
+	err := session.SavePacket(Outgoing, publish)
+	assert.NoError(t, err)
+
+	err = session.SavePacket(Incoming, packet.NewPubrel())
+	assert.NoError(t, err)
+
+	id, err := session.NextID()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.ID(1), id)
+
+	id, err = session.NextID()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.ID(3), id)
+
+	for i := 1; i <= math.MaxUint16; i++ {
+		publish := packet.NewPublish()
+		publish.ID = packet.ID(i)
+
+		err = session.SavePacket(Outgoing, publish)
+		assert.NoError(t, err)
+	}
+
+	id, err = session.NextID()
+	assert.Equal(t, ErrNoFreeID, err)
+	assert.Equal(t, packet.ID(0), id)
 
-	err := session.Reset()
+	err = session.DeletePacket(Outgoing, 42)
 	assert.NoError(t, err)
 
-	assert.Equal(t, packet.ID(1), session.NextID())
+	id, err = session.NextID()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.ID(42), id)
 }
 
 func TestMemorySessionPacketStore(t *testing.T) {
@@ -74,3 +127,33 @@ func TestMemorySessionPacketStore(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 0, len(list))
 }
+
+func TestMemorySessionKeys(t *testing.T) {
+	session := NewMemorySession()
+
+	now := time.Now()
+
+	err := session.SaveKey("foo", now)
+	assert.NoError(t, err)
+
+	keys, err := session.AllKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]time.Time{"foo": now}, keys)
+
+	err = session.DeleteKey("foo")
+	assert.NoError(t, err)
+
+	keys, err = session.AllKeys()
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	err = session.SaveKey("bar", now)
+	assert.NoError(t, err)
+
+	err = session.Reset()
+	assert.NoError(t, err)
+
+	keys, err = session.AllKeys()
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
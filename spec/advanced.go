@@ -1,6 +1,8 @@
 package spec
 
 import (
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,7 +16,7 @@ import (
 func AuthenticationTest(t *testing.T, config *Config) {
 	deniedClient := client.New()
 	deniedClient.Callback = func(msg *packet.Message, err error) error {
-		assert.Equal(t, client.ErrClientConnectionDenied, err)
+		assert.True(t, errors.Is(err, client.ErrClientConnectionDenied))
 		return nil
 	}
 
@@ -152,3 +154,68 @@ func RootSlashDistinctionTest(t *testing.T, config *Config, topic string) {
 	err = c.Disconnect()
 	assert.NoError(t, err)
 }
+
+// SystemTopicsTest tests the broker for not matching system topics using
+// wildcards on the first level.
+func SystemTopicsTest(t *testing.T, config *Config, topic string) {
+	c1 := client.New()
+
+	c1.Callback = func(msg *packet.Message, err error) error {
+		assert.NoError(t, err)
+		assert.False(t, strings.HasPrefix(msg.Topic, "$"), msg.Topic)
+		return nil
+	}
+
+	cf, err := c1.Connect(client.NewConfig(config.URL))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+	assert.Equal(t, packet.ConnectionAccepted, cf.ReturnCode())
+	assert.False(t, cf.SessionPresent())
+
+	sf, err := c1.SubscribeMultiple([]packet.Subscription{
+		{Topic: "#", QOS: 0},
+		{Topic: "+/" + topic, QOS: 0},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, sf.Wait(10*time.Second))
+	assert.Equal(t, []packet.QOS{0, 0}, sf.ReturnCodes())
+
+	c2 := client.New()
+	wait := make(chan struct{})
+
+	c2.Callback = func(msg *packet.Message, err error) error {
+		assert.NoError(t, err)
+		assert.Equal(t, "$spec/"+topic, msg.Topic)
+		assert.Equal(t, testPayload, msg.Payload)
+		assert.Equal(t, packet.QOS(0), msg.QOS)
+		assert.False(t, msg.Retain)
+
+		close(wait)
+		return nil
+	}
+
+	cf, err = c2.Connect(client.NewConfig(config.URL))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+	assert.Equal(t, packet.ConnectionAccepted, cf.ReturnCode())
+	assert.False(t, cf.SessionPresent())
+
+	sf, err = c2.Subscribe("$spec/"+topic, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, sf.Wait(10*time.Second))
+	assert.Equal(t, []packet.QOS{0}, sf.ReturnCodes())
+
+	pf, err := c2.Publish("$spec/"+topic, testPayload, 0, false)
+	assert.NoError(t, err)
+	assert.NoError(t, pf.Wait(10*time.Second))
+
+	safeReceive(wait)
+
+	time.Sleep(config.NoMessageWait)
+
+	err = c1.Disconnect()
+	assert.NoError(t, err)
+
+	err = c2.Disconnect()
+	assert.NoError(t, err)
+}
@@ -23,6 +23,7 @@ type Config struct {
 	Authentication       bool
 	UniqueClientIDs      bool
 	RootSlashDistinction bool
+	SystemTopics         bool
 
 	// ProcessWait defines the time some tests should wait and let the broker
 	// finish processing (e.g. properly terminating a connection)
@@ -49,6 +50,7 @@ func AllFeatures() *Config {
 		Authentication:       true,
 		UniqueClientIDs:      true,
 		RootSlashDistinction: true,
+		SystemTopics:         true,
 	}
 }
 
@@ -349,4 +351,10 @@ func Run(t *testing.T, config *Config) {
 			RootSlashDistinctionTest(t, config, "rootslash")
 		})
 	}
+
+	if config.SystemTopics {
+		t.Run("SystemTopics", func(t *testing.T) {
+			SystemTopicsTest(t, config, "systopic")
+		})
+	}
 }
@@ -0,0 +1,316 @@
+package topic
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// the number of values up to which duplicates are detected using a linear scan
+const matcherScanLimit = 16
+
+type matcherNode[T comparable] struct {
+	children map[string]*matcherNode[T]
+	values   []T
+}
+
+func (n *matcherNode[T]) copy() *matcherNode[T] {
+	// prepare node
+	c := &matcherNode[T]{
+		children: make(map[string]*matcherNode[T], len(n.children)),
+		values:   n.values,
+	}
+
+	// copy children
+	for key, child := range n.children {
+		c.children[key] = child
+	}
+
+	return c
+}
+
+func (n *matcherNode[T]) empty() bool {
+	return len(n.values) == 0 && len(n.children) == 0
+}
+
+type matcherCache[T comparable] struct {
+	entries sync.Map
+	size    int64
+}
+
+type matcherResult[T comparable] struct {
+	values []T
+	seen   map[T]struct{}
+	large  bool
+}
+
+func (r *matcherResult[T]) reset() {
+	// clear values and set
+	clear(r.values)
+	r.values = r.values[:0]
+	clear(r.seen)
+	r.large = false
+}
+
+func (r *matcherResult[T]) add(values []T) {
+	for _, value := range values {
+		// use a linear scan for small results
+		if !r.large && len(r.values) < matcherScanLimit {
+			found := false
+			for _, v := range r.values {
+				if v == value {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				r.values = append(r.values, value)
+			}
+
+			continue
+		}
+
+		// prepare set
+		if !r.large {
+			if r.seen == nil {
+				r.seen = make(map[T]struct{}, len(r.values)*2)
+			}
+			for _, v := range r.values {
+				r.seen[v] = struct{}{}
+			}
+			r.large = true
+		}
+
+		// use set for large results
+		if _, ok := r.seen[value]; !ok {
+			r.seen[value] = struct{}{}
+			r.values = append(r.values, value)
+		}
+	}
+}
+
+// A Matcher is an optimized alternative to the TypedTree that only supports
+// matching topics against stored subscription filters. The matcher uses the
+// standard "/" separator and "+" and "#" wildcards.
+//
+// The tree is copied on write, which allows matching topics without acquiring
+// any locks. In return, adding and removing values is more expensive than in
+// the TypedTree and should be less frequent than matching. Apart from the
+// returned slice, matching does not allocate as the buffers used to collect
+// and de-duplicate values are reused.
+type Matcher[T comparable] struct {
+	// CacheSize can be set to cache up to the specified number of match
+	// results. The cache is invalidated on every change of the matcher.
+	//
+	// Will default to no caching if zero.
+	CacheSize int
+
+	root  atomic.Pointer[matcherNode[T]]
+	cache atomic.Pointer[matcherCache[T]]
+	pool  sync.Pool
+	mutex sync.Mutex
+}
+
+// NewMatcher returns a new Matcher.
+func NewMatcher[T comparable]() *Matcher[T] {
+	// prepare matcher
+	m := &Matcher[T]{}
+	m.root.Store(&matcherNode[T]{})
+	m.cache.Store(&matcherCache[T]{})
+
+	return m
+}
+
+// Add registers the value for the supplied filter. If the value already exists
+// for the filter it will not be added again.
+func (m *Matcher[T]) Add(filter string, value T) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// add value
+	root := m.update(m.root.Load(), filter, false, func(values []T) []T {
+		// check existing values
+		for _, v := range values {
+			if v == value {
+				return values
+			}
+		}
+
+		// copy values to not modify a previous version
+		list := make([]T, len(values), len(values)+1)
+		copy(list, values)
+
+		return append(list, value)
+	})
+
+	// publish new tree
+	m.commit(root)
+}
+
+// Remove un-registers the value from the supplied filter.
+func (m *Matcher[T]) Remove(filter string, value T) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// remove value
+	root := m.update(m.root.Load(), filter, false, func(values []T) []T {
+		// copy remaining values to not modify a previous version
+		var list []T
+		for _, v := range values {
+			if v != value {
+				list = append(list, v)
+			}
+		}
+
+		return list
+	})
+
+	// publish new tree
+	m.commit(root)
+}
+
+// Reset will remove all values from the matcher.
+func (m *Matcher[T]) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.commit(&matcherNode[T]{})
+}
+
+func (m *Matcher[T]) update(node *matcherNode[T], filter string, end bool, fn func([]T) []T) *matcherNode[T] {
+	// copy node
+	node = node.copy()
+
+	// update values on leaf
+	if end {
+		node.values = fn(node.values)
+		return node
+	}
+
+	// get segment
+	segment, rest, last := nextSegment(filter)
+
+	// get or create child
+	child, ok := node.children[segment]
+	if !ok {
+		child = &matcherNode[T]{}
+	}
+
+	// update child
+	child = m.update(child, rest, last, fn)
+
+	// set or remove child
+	if child.empty() {
+		delete(node.children, segment)
+	} else {
+		node.children[segment] = child
+	}
+
+	return node
+}
+
+func (m *Matcher[T]) commit(root *matcherNode[T]) {
+	// publish the tree before invalidating the cache so that results of the
+	// previous tree can only end up in the old cache
+	m.root.Store(root)
+	m.cache.Store(&matcherCache[T]{})
+}
+
+// Match will return a set of values from filters that match the supplied
+// topic. Wildcards on the first level do not match system topics that start
+// with a "$" character. The returned slice must not be modified if caching is
+// enabled.
+func (m *Matcher[T]) Match(topic string) []T {
+	// get cache and check for a cached result
+	cache := m.cache.Load()
+	if m.CacheSize > 0 {
+		if values, ok := cache.entries.Load(topic); ok {
+			return values.([]T)
+		}
+	}
+
+	// get a previously used result to reuse its buffers
+	result, _ := m.pool.Get().(*matcherResult[T])
+	if result == nil {
+		result = &matcherResult[T]{}
+	}
+
+	// match topic
+	m.match(result, m.root.Load(), topic, false, !IsSystem(topic))
+
+	// copy values to allocate them only once
+	var values []T
+	if len(result.values) > 0 {
+		values = make([]T, len(result.values))
+		copy(values, result.values)
+	}
+
+	// recycle result
+	result.reset()
+	m.pool.Put(result)
+
+	// cache result if there is space left
+	if m.CacheSize > 0 && atomic.AddInt64(&cache.size, 1) <= int64(m.CacheSize) {
+		cache.entries.Store(topic, values)
+	}
+
+	return values
+}
+
+func (m *Matcher[T]) match(result *matcherResult[T], node *matcherNode[T], topic string, end bool, wildcards bool) {
+	// add all values that match multiple levels
+	if child, ok := node.children["#"]; ok && wildcards {
+		result.add(child.values)
+	}
+
+	// add all values when finished
+	if end {
+		result.add(node.values)
+		return
+	}
+
+	// get segment
+	segment, rest, last := nextSegment(topic)
+
+	// advance children that match a single level
+	if child, ok := node.children["+"]; ok && wildcards {
+		m.match(result, child, rest, last, true)
+	}
+
+	// advance children that match the segment
+	if segment != "+" && segment != "#" {
+		if child, ok := node.children[segment]; ok {
+			m.match(result, child, rest, last, true)
+		}
+	}
+}
+
+// Count will count all stored values in the matcher.
+func (m *Matcher[T]) Count() int {
+	return m.count(m.root.Load())
+}
+
+func (m *Matcher[T]) count(node *matcherNode[T]) int {
+	// prepare total
+	total := len(node.values)
+
+	// add children
+	for _, child := range node.children {
+		total += m.count(child)
+	}
+
+	return total
+}
+
+// nextSegment returns the first segment of the topic, the remaining topic and
+// whether the segment has been the last one without allocating.
+func nextSegment(topic string) (string, string, bool) {
+	// find separator
+	i := strings.IndexByte(topic, '/')
+	if i < 0 {
+		return topic, "", true
+	}
+
+	return topic[:i], topic[i+1:], false
+}
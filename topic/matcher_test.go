@@ -0,0 +1,284 @@
+package topic
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatcherAdd(t *testing.T) {
+	matcher := NewMatcher[int]()
+
+	matcher.Add("foo/bar", 1)
+	matcher.Add("foo/bar", 1)
+
+	assert.Equal(t, []int{1}, matcher.root.Load().children["foo"].children["bar"].values)
+	assert.Equal(t, 1, matcher.Count())
+}
+
+func TestMatcherRemove(t *testing.T) {
+	matcher := NewMatcher[int]()
+
+	matcher.Add("foo/bar", 1)
+	matcher.Add("foo/bar", 2)
+	matcher.Remove("foo/bar", 1)
+
+	assert.Equal(t, []int{2}, matcher.Match("foo/bar"))
+
+	matcher.Remove("foo/bar", 2)
+	assert.Equal(t, 0, len(matcher.root.Load().children))
+
+	matcher.Remove("foo/baz", 3)
+	assert.Equal(t, 0, len(matcher.root.Load().children))
+}
+
+func TestMatcherReset(t *testing.T) {
+	matcher := NewMatcher[int]()
+
+	matcher.Add("foo/bar", 1)
+	matcher.Reset()
+
+	assert.Equal(t, 0, matcher.Count())
+	assert.Empty(t, matcher.Match("foo/bar"))
+}
+
+func TestMatcherMatch(t *testing.T) {
+	matcher := NewMatcher[int]()
+
+	matcher.Add("foo/bar", 1)
+	matcher.Add("foo/+", 2)
+	matcher.Add("foo/#", 3)
+	matcher.Add("#", 4)
+	matcher.Add("+/+/+", 5)
+	matcher.Add("foo//bar", 6)
+	matcher.Add("foo/bar/", 7)
+
+	assert.ElementsMatch(t, []int{1, 2, 3, 4}, matcher.Match("foo/bar"))
+	assert.ElementsMatch(t, []int{3, 4}, matcher.Match("foo"))
+	assert.ElementsMatch(t, []int{3, 4, 5}, matcher.Match("foo/bar/baz"))
+	assert.ElementsMatch(t, []int{3, 4, 5, 6}, matcher.Match("foo//bar"))
+	assert.ElementsMatch(t, []int{3, 4, 5, 7}, matcher.Match("foo/bar/"))
+	assert.ElementsMatch(t, []int{4}, matcher.Match("bar"))
+	assert.ElementsMatch(t, []int{3, 4, 5}, matcher.Match("foo/+/#"))
+}
+
+func TestMatcherMatchNoDuplicates(t *testing.T) {
+	matcher := NewMatcher[int]()
+
+	for i := 0; i < matcherScanLimit*2; i++ {
+		matcher.Add("foo/bar", i)
+		matcher.Add("foo/+", i)
+		matcher.Add("foo/#", i)
+	}
+
+	assert.Len(t, matcher.Match("foo/bar"), matcherScanLimit*2)
+}
+
+func TestMatcherMatchSystemTopic(t *testing.T) {
+	matcher := NewMatcher[int]()
+
+	matcher.Add("#", 1)
+	matcher.Add("+/foo", 2)
+	matcher.Add("$SYS/#", 3)
+	matcher.Add("$SYS/+", 4)
+	matcher.Add("$SYS/foo", 5)
+
+	assert.ElementsMatch(t, []int{3, 4, 5}, matcher.Match("$SYS/foo"))
+	assert.ElementsMatch(t, []int{1, 2}, matcher.Match("bar/foo"))
+}
+
+func TestMatcherCache(t *testing.T) {
+	matcher := NewMatcher[int]()
+	matcher.CacheSize = 1
+
+	matcher.Add("foo/+", 1)
+	assert.Equal(t, []int{1}, matcher.Match("foo/bar"))
+	assert.Equal(t, []int{1}, matcher.Match("foo/bar"))
+	assert.Equal(t, []int{1}, matcher.Match("foo/baz"))
+
+	matcher.Add("foo/bar", 2)
+	assert.ElementsMatch(t, []int{1, 2}, matcher.Match("foo/bar"))
+
+	matcher.Remove("foo/+", 1)
+	assert.Equal(t, []int{2}, matcher.Match("foo/bar"))
+}
+
+func TestMatcherConcurrency(t *testing.T) {
+	matcher := NewMatcher[int]()
+	matcher.CacheSize = 100
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				matcher.Add(fmt.Sprintf("foo/%d/+", j), i)
+				matcher.Match(fmt.Sprintf("foo/%d/bar", j))
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, 1000, matcher.Count())
+	assert.Len(t, matcher.Match("foo/42/bar"), 10)
+}
+
+func benchmarkFilters(n int) []string {
+	// prepare filters
+	filters := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		switch i % 4 {
+		case 0:
+			filters = append(filters, fmt.Sprintf("devices/%d/sensors/%d", i%1000, i))
+		case 1:
+			filters = append(filters, fmt.Sprintf("devices/%d/sensors/+", i%1000))
+		case 2:
+			filters = append(filters, fmt.Sprintf("devices/%d/#", i%1000))
+		case 3:
+			filters = append(filters, fmt.Sprintf("devices/+/sensors/%d", i%1000))
+		}
+	}
+
+	return filters
+}
+
+func benchmarkTopics(n int) []string {
+	// prepare topics
+	topics := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		topics = append(topics, fmt.Sprintf("devices/%d/sensors/%d", i, i*4))
+	}
+
+	return topics
+}
+
+var benchmarkTree *Tree
+var benchmarkMatcher *Matcher[int]
+var benchmarkOnce sync.Once
+
+func benchmarkLarge() (*Tree, *Matcher[int]) {
+	// build tree and matcher once as adding to the matcher is expensive
+	benchmarkOnce.Do(func() {
+		benchmarkTree = NewTree()
+		benchmarkMatcher = NewMatcher[int]()
+
+		for i, filter := range benchmarkFilters(200000) {
+			benchmarkTree.Add(filter, i)
+			benchmarkMatcher.Add(filter, i)
+		}
+	})
+
+	return benchmarkTree, benchmarkMatcher
+}
+
+func BenchmarkMatcherAddUnique(b *testing.B) {
+	matcher := NewMatcher[int]()
+
+	strings := make([]string, 0, b.N)
+
+	for i := 0; i < b.N; i++ {
+		strings = append(strings, fmt.Sprintf("foo/%d", i%1000))
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		matcher.Add(strings[i], i)
+	}
+}
+
+func BenchmarkMatcherMatchExact(b *testing.B) {
+	matcher := NewMatcher[int]()
+	matcher.Add("foo/bar", 1)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		matcher.Match("foo/bar")
+	}
+}
+
+func BenchmarkMatcherMatchWildcardOne(b *testing.B) {
+	matcher := NewMatcher[int]()
+	matcher.Add("foo/+", 1)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		matcher.Match("foo/bar")
+	}
+}
+
+func BenchmarkMatcherMatchWildcardSome(b *testing.B) {
+	matcher := NewMatcher[int]()
+	matcher.Add("#", 1)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		matcher.Match("foo/bar")
+	}
+}
+
+func BenchmarkTreeMatchLarge(b *testing.B) {
+	tree, _ := benchmarkLarge()
+	topics := benchmarkTopics(1000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			tree.Match(topics[i%len(topics)])
+			i++
+		}
+	})
+}
+
+func BenchmarkMatcherMatchLarge(b *testing.B) {
+	_, matcher := benchmarkLarge()
+	topics := benchmarkTopics(1000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			matcher.Match(topics[i%len(topics)])
+			i++
+		}
+	})
+}
+
+func BenchmarkMatcherMatchLargeCached(b *testing.B) {
+	_, matcher := benchmarkLarge()
+	topics := benchmarkTopics(1000)
+
+	matcher.CacheSize = len(topics)
+	defer func() {
+		matcher.CacheSize = 0
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			matcher.Match(topics[i%len(topics)])
+			i++
+		}
+	})
+}
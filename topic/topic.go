@@ -5,38 +5,93 @@ import (
 	"errors"
 	"regexp"
 	"strings"
+	"unicode/utf8"
 )
 
+// MaxLength is the maximum length of a topic in bytes.
+const MaxLength = 65535
+
 // ErrZeroLength is returned by Parse if a topics has a zero length.
 var ErrZeroLength = errors.New("zero length topic")
 
 // ErrWildcards is returned by Parse if a topic contains invalid wildcards.
 var ErrWildcards = errors.New("invalid use of wildcards")
 
+// ErrTooLong is returned by Parse in strict mode if a topic exceeds MaxLength.
+var ErrTooLong = errors.New("topic too long")
+
+// ErrInvalidCharacters is returned by Parse in strict mode if a topic contains
+// the null character or is not valid UTF-8.
+var ErrInvalidCharacters = errors.New("invalid characters")
+
+// ErrTooDeep is returned by Parse if a topic has more levels than allowed.
+var ErrTooDeep = errors.New("topic too deep")
+
 var multiSlashRegex = regexp.MustCompile(`/+`)
 
+// A Parser validates topics either in normalizing or in strict mode.
+type Parser struct {
+	// Strict will preserve empty levels instead of removing duplicate and
+	// trailing slashes. Topics that are longer than MaxLength, contain the null
+	// character or are not valid UTF-8 are rejected.
+	Strict bool
+
+	// MaxDepth can be set to reject topics with more levels.
+	//
+	// Will default to unlimited if zero.
+	MaxDepth int
+}
+
 // Parse removes duplicate and trailing slashes from the supplied
-// string and returns the normalized topic.
+// string and returns the normalized topic. The leading "$" character of system
+// topics is preserved and must be checked using IsSystem.
 func Parse(topic string, allowWildcards bool) (string, error) {
+	return (&Parser{}).Parse(topic, allowWildcards)
+}
+
+// Parse validates the supplied string and returns the topic. Unless in strict
+// mode, duplicate and trailing slashes are removed.
+func (p *Parser) Parse(topic string, allowWildcards bool) (string, error) {
 	// check for zero length
 	if topic == "" {
 		return "", ErrZeroLength
 	}
 
+	// check topic in strict mode
+	if p.Strict {
+		// check length
+		if len(topic) > MaxLength {
+			return "", ErrTooLong
+		}
+
+		// check characters
+		if strings.IndexByte(topic, 0) >= 0 || !utf8.ValidString(topic) {
+			return "", ErrInvalidCharacters
+		}
+	}
+
 	// normalize topic
-	topic = multiSlashRegex.ReplaceAllString(topic, "/")
+	if !p.Strict {
+		// remove duplicate slashes
+		topic = multiSlashRegex.ReplaceAllString(topic, "/")
 
-	// remove trailing slashes
-	topic = strings.TrimRight(topic, "/")
+		// remove trailing slashes
+		topic = strings.TrimRight(topic, "/")
 
-	// check again for zero length
-	if topic == "" {
-		return "", ErrZeroLength
+		// check again for zero length
+		if topic == "" {
+			return "", ErrZeroLength
+		}
 	}
 
 	// split to segments
 	segments := strings.Split(topic, "/")
 
+	// check depth
+	if p.MaxDepth > 0 && len(segments) > p.MaxDepth {
+		return "", ErrTooDeep
+	}
+
 	// check all segments
 	for i, s := range segments {
 		// check use of wildcards
@@ -63,3 +118,10 @@ func Parse(topic string, allowWildcards bool) (string, error) {
 func ContainsWildcards(topic string) bool {
 	return strings.Contains(topic, "+") || strings.Contains(topic, "#")
 }
+
+// IsSystem tests if the supplied topic is a system topic (e.g. "$SYS/foo") that
+// starts with a "$" character. Wildcards on the first level of a subscription
+// must not match system topics.
+func IsSystem(topic string) bool {
+	return strings.HasPrefix(topic, "$")
+}
@@ -1,6 +1,7 @@
 package topic
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -93,8 +94,78 @@ func TestTopicAllowWildcards(t *testing.T) {
 	}
 }
 
+func TestStrictParser(t *testing.T) {
+	parser := &Parser{Strict: true}
+
+	tests := map[string]string{
+		"topic/hello":   "topic/hello",
+		"topic//hello":  "topic//hello",
+		"/topic":        "/topic",
+		"//topic":       "//topic",
+		"topic/":        "topic/",
+		"topic//":       "topic//",
+		"/":             "/",
+		"topic/+/hello": "topic/+/hello",
+		"topic//#":      "topic//#",
+	}
+
+	for str, result := range tests {
+		str, err := parser.Parse(str, true)
+		assert.Equal(t, result, str)
+		assert.NoError(t, err, str)
+	}
+}
+
+func TestStrictParserErrors(t *testing.T) {
+	parser := &Parser{Strict: true}
+
+	tests := map[string]error{
+		"":                                    ErrZeroLength,
+		strings.Repeat("a", MaxLength+1):      ErrTooLong,
+		"topic/" + string([]byte{0}):          ErrInvalidCharacters,
+		"topic/" + string([]byte{0xff}):       ErrInvalidCharacters,
+		"topic/#/hello":                       ErrWildcards,
+		"topic/++":                            ErrWildcards,
+		strings.Repeat("a", MaxLength):        nil,
+		"topic/" + string([]byte{0xc3, 0xa4}): nil,
+	}
+
+	for str, result := range tests {
+		_, err := parser.Parse(str, true)
+		assert.Equal(t, result, err, str)
+	}
+}
+
+func TestParserMaxDepth(t *testing.T) {
+	parser := &Parser{MaxDepth: 3}
+
+	_, err := parser.Parse("a/b/c", false)
+	assert.NoError(t, err)
+
+	_, err = parser.Parse("a//b///c//", false)
+	assert.NoError(t, err)
+
+	_, err = parser.Parse("a/b/c/d", false)
+	assert.Equal(t, ErrTooDeep, err)
+
+	parser.Strict = true
+
+	_, err = parser.Parse("a//b", false)
+	assert.NoError(t, err)
+
+	_, err = parser.Parse("a//b/", false)
+	assert.Equal(t, ErrTooDeep, err)
+}
+
 func TestTopicContainsWildcards(t *testing.T) {
 	assert.True(t, ContainsWildcards("topic/+"))
 	assert.True(t, ContainsWildcards("topic/#"))
 	assert.False(t, ContainsWildcards("topic/hello"))
 }
+
+func TestIsSystem(t *testing.T) {
+	assert.True(t, IsSystem("$SYS/foo"))
+	assert.True(t, IsSystem("$foo"))
+	assert.False(t, IsSystem("foo/$SYS"))
+	assert.False(t, IsSystem("/$SYS"))
+}
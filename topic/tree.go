@@ -1,25 +1,28 @@
 package topic
 
 import (
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 )
 
-type node struct {
-	children map[string]*node
-	values   []interface{}
+type node[T any] struct {
+	children map[string]*node[T]
+	values   []T
 }
 
-func newNode() *node {
-	return &node{
-		children: make(map[string]*node),
+func newNode[T any]() *node[T] {
+	return &node[T]{
+		children: make(map[string]*node[T]),
 	}
 }
 
-func (n *node) removeValue(value interface{}) {
+func (n *node[T]) removeValue(value T, equal func(a, b T) bool) {
 	for i, v := range n.values {
-		if v == value {
+		if equal(v, value) {
 			// remove without preserving order
 			n.values[i] = n.values[len(n.values)-1]
 			n.values = n.values[:len(n.values)-1]
@@ -28,11 +31,11 @@ func (n *node) removeValue(value interface{}) {
 	}
 }
 
-func (n *node) clearValues() {
-	n.values = []interface{}{}
+func (n *node[T]) clearValues() {
+	n.values = []T{}
 }
 
-func (n *node) string(i int) string {
+func (n *node[T]) string(i int) string {
 	str := ""
 
 	if i != 0 {
@@ -46,8 +49,9 @@ func (n *node) string(i int) string {
 	return str
 }
 
-// A Tree implements a thread-safe topic tree.
-type Tree struct {
+// A TypedTree implements a thread-safe topic tree that stores values of the
+// type T.
+type TypedTree[T any] struct {
 	// The separator character. Default: "/"
 	Separator string
 
@@ -57,36 +61,56 @@ type Tree struct {
 	// The multi level wildcard character. Default "#"
 	WildcardSome string
 
-	root  *node
+	equal func(a, b T) bool
+	root  *node[T]
 	mutex sync.RWMutex
 }
 
+// A Tree implements a thread-safe topic tree that stores arbitrary values.
+// Values are compared using the equality operator, values of uncomparable types
+// like maps and slices are compared using reflect.DeepEqual.
+type Tree = TypedTree[interface{}]
+
 // NewTree returns a new Tree.
 func NewTree() *Tree {
-	return &Tree{
+	return NewTypedTree[interface{}](nil)
+}
+
+// NewTypedTree returns a new TypedTree that uses the supplied function to
+// compare values. If no function is supplied, values are compared using the
+// equality operator. If T is an interface or not comparable, values of
+// uncomparable dynamic types are compared using reflect.DeepEqual.
+func NewTypedTree[T any](equal func(a, b T) bool) *TypedTree[T] {
+	// set default equality
+	if equal == nil {
+		equal = defaultEqual[T]()
+	}
+
+	return &TypedTree[T]{
 		Separator:    "/",
 		WildcardOne:  "+",
 		WildcardSome: "#",
 
-		root: newNode(),
+		equal: equal,
+		root:  newNode[T](),
 	}
 }
 
 // Add registers the value for the supplied topic. This function will
 // automatically grow the tree. If value already exists for the given topic it
 // will not be added again.
-func (t *Tree) Add(topic string, value interface{}) {
+func (t *TypedTree[T]) Add(topic string, value T) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
 	t.add(value, 0, strings.Split(topic, t.Separator), t.root)
 }
 
-func (t *Tree) add(value interface{}, i int, segments []string, node *node) {
+func (t *TypedTree[T]) add(value T, i int, segments []string, node *node[T]) {
 	// add value to leaf
 	if i == len(segments) {
 		for _, v := range node.values {
-			if v == value {
+			if t.equal(v, value) {
 				return
 			}
 		}
@@ -100,7 +124,7 @@ func (t *Tree) add(value interface{}, i int, segments []string, node *node) {
 
 	// create missing node
 	if !ok {
-		child = newNode()
+		child = newNode[T]()
 		node.children[segment] = child
 	}
 
@@ -109,17 +133,17 @@ func (t *Tree) add(value interface{}, i int, segments []string, node *node) {
 
 // Set sets the supplied value as the only value for the supplied topic. This
 // function will automatically grow the tree.
-func (t *Tree) Set(topic string, value interface{}) {
+func (t *TypedTree[T]) Set(topic string, value T) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
 	t.set(value, 0, strings.Split(topic, t.Separator), t.root)
 }
 
-func (t *Tree) set(value interface{}, i int, segments []string, node *node) {
+func (t *TypedTree[T]) set(value T, i int, segments []string, node *node[T]) {
 	// set value on leaf
 	if i == len(segments) {
-		node.values = []interface{}{value}
+		node.values = []T{value}
 		return
 	}
 
@@ -128,7 +152,7 @@ func (t *Tree) set(value interface{}, i int, segments []string, node *node) {
 
 	// create missing node
 	if !ok {
-		child = newNode()
+		child = newNode[T]()
 		node.children[segment] = child
 	}
 
@@ -136,14 +160,14 @@ func (t *Tree) set(value interface{}, i int, segments []string, node *node) {
 }
 
 // Get gets the values from the topic that exactly matches the supplied topics.
-func (t *Tree) Get(topic string) []interface{} {
+func (t *TypedTree[T]) Get(topic string) []T {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
 	return t.get(0, strings.Split(topic, t.Separator), t.root)
 }
 
-func (t *Tree) get(i int, segments []string, node *node) []interface{} {
+func (t *TypedTree[T]) get(i int, segments []string, node *node[T]) []T {
 	// set value on leaf
 	if i == len(segments) {
 		return node.values
@@ -161,29 +185,30 @@ func (t *Tree) get(i int, segments []string, node *node) []interface{} {
 
 // Remove un-registers the value from the supplied topic. This function will
 // automatically shrink the tree.
-func (t *Tree) Remove(topic string, value interface{}) {
+func (t *TypedTree[T]) Remove(topic string, value T) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	t.remove(value, 0, strings.Split(topic, t.Separator), t.root)
+	t.remove(value, false, 0, strings.Split(topic, t.Separator), t.root)
 }
 
 // Empty will unregister all values from the supplied topic. This function will
 // automatically shrink the tree.
-func (t *Tree) Empty(topic string) {
+func (t *TypedTree[T]) Empty(topic string) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	t.remove(nil, 0, strings.Split(topic, t.Separator), t.root)
+	var zero T
+	t.remove(zero, true, 0, strings.Split(topic, t.Separator), t.root)
 }
 
-func (t *Tree) remove(value interface{}, i int, segments []string, node *node) bool {
+func (t *TypedTree[T]) remove(value T, empty bool, i int, segments []string, node *node[T]) bool {
 	// clear or remove value from leaf node
 	if i == len(segments) {
-		if value == nil {
+		if empty {
 			node.clearValues()
 		} else {
-			node.removeValue(value)
+			node.removeValue(value, t.equal)
 		}
 
 		return len(node.values) == 0 && len(node.children) == 0
@@ -197,7 +222,7 @@ func (t *Tree) remove(value interface{}, i int, segments []string, node *node) b
 		return false
 	}
 
-	if t.remove(value, i+1, segments, child) {
+	if t.remove(value, empty, i+1, segments, child) {
 		delete(node.children, segment)
 	}
 
@@ -206,15 +231,15 @@ func (t *Tree) remove(value interface{}, i int, segments []string, node *node) b
 
 // Clear will unregister the supplied value from all topics. This function will
 // automatically shrink the tree.
-func (t *Tree) Clear(value interface{}) {
+func (t *TypedTree[T]) Clear(value T) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
 	t.clear(value, t.root)
 }
 
-func (t *Tree) clear(value interface{}, node *node) bool {
-	node.removeValue(value)
+func (t *TypedTree[T]) clear(value T, node *node[T]) bool {
+	node.removeValue(value, t.equal)
 
 	// remove value from all nodes
 	for segment, child := range node.children {
@@ -227,23 +252,27 @@ func (t *Tree) clear(value interface{}, node *node) bool {
 }
 
 // Match will return a set of values from topics that match the supplied topic.
-// The result set will be cleared from duplicate values.
+// The result set will be cleared from duplicate values. Wildcards on the first
+// level do not match system topics that start with a "$" character.
 //
 // Note: In contrast to Search, Match does not respect wildcards in the query but
 // in the stored tree.
-func (t *Tree) Match(topic string) []interface{} {
+func (t *TypedTree[T]) Match(topic string) []T {
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
 
 	segments := strings.Split(topic, t.Separator)
-	values := t.match([]interface{}{}, 0, segments, t.root)
+	values := t.match([]T{}, 0, segments, t.root)
 
 	return t.clean(values)
 }
 
-func (t *Tree) match(result []interface{}, i int, segments []string, node *node) []interface{} {
+func (t *TypedTree[T]) match(result []T, i int, segments []string, node *node[T]) []T {
+	// wildcards on the first level must not match system topics
+	wildcards := i > 0 || !IsSystem(segments[0])
+
 	// add all values to the result set that match multiple levels
-	if child, ok := node.children[t.WildcardSome]; ok {
+	if child, ok := node.children[t.WildcardSome]; ok && wildcards {
 		result = append(result, child.values...)
 	}
 
@@ -253,7 +282,7 @@ func (t *Tree) match(result []interface{}, i int, segments []string, node *node)
 	}
 
 	// advance children that match a single level
-	if child, ok := node.children[t.WildcardOne]; ok {
+	if child, ok := node.children[t.WildcardOne]; ok && wildcards {
 		result = t.match(result, i+1, segments, child)
 	}
 
@@ -269,33 +298,35 @@ func (t *Tree) match(result []interface{}, i int, segments []string, node *node)
 	return result
 }
 
-// MatchFirst will run Match and return the first value or nil.
-func (t *Tree) MatchFirst(topic string) interface{} {
+// MatchFirst will run Match and return the first value or the zero value of T.
+func (t *TypedTree[T]) MatchFirst(topic string) T {
 	values := t.Match(topic)
 
 	if len(values) > 0 {
 		return values[0]
 	}
 
-	return nil
+	var zero T
+	return zero
 }
 
 // Search will return a set of values from topics that match the supplied topic.
-// The result set will be cleared from duplicate values.
+// The result set will be cleared from duplicate values. Wildcards on the first
+// level do not match system topics that start with a "$" character.
 //
 // Note: In contrast to Match, Search respects wildcards in the query but not in
 // the stored tree.
-func (t *Tree) Search(topic string) []interface{} {
+func (t *TypedTree[T]) Search(topic string) []T {
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
 
 	segments := strings.Split(topic, t.Separator)
-	values := t.search([]interface{}{}, 0, segments, t.root)
+	values := t.search([]T{}, 0, segments, t.root)
 
 	return t.clean(values)
 }
 
-func (t *Tree) search(result []interface{}, i int, segments []string, node *node) []interface{} {
+func (t *TypedTree[T]) search(result []T, i int, segments []string, node *node[T]) []T {
 	// when finished add all values to the result set
 	if i == len(segments) {
 		return append(result, node.values...)
@@ -308,7 +339,12 @@ func (t *Tree) search(result []interface{}, i int, segments []string, node *node
 	if segment == t.WildcardSome {
 		result = append(result, node.values...)
 
-		for _, child := range node.children {
+		for key, child := range node.children {
+			// wildcards on the first level must not match system topics
+			if node == t.root && IsSystem(key) {
+				continue
+			}
+
 			result = t.search(result, i, segments, child)
 		}
 	}
@@ -317,7 +353,12 @@ func (t *Tree) search(result []interface{}, i int, segments []string, node *node
 	if segment == t.WildcardOne {
 		result = append(result, node.values...)
 
-		for _, child := range node.children {
+		for key, child := range node.children {
+			// wildcards on the first level must not match system topics
+			if node == t.root && IsSystem(key) {
+				continue
+			}
+
 			result = t.search(result, i+1, segments, child)
 		}
 	}
@@ -332,23 +373,25 @@ func (t *Tree) search(result []interface{}, i int, segments []string, node *node
 	return result
 }
 
-// SearchFirst will run Search and return the first value or nil.
-func (t *Tree) SearchFirst(topic string) interface{} {
+// SearchFirst will run Search and return the first value or the zero value of
+// T.
+func (t *TypedTree[T]) SearchFirst(topic string) T {
 	values := t.Search(topic)
 
 	if len(values) > 0 {
 		return values[0]
 	}
 
-	return nil
+	var zero T
+	return zero
 }
 
 // clean will remove duplicates
-func (t *Tree) clean(values []interface{}) []interface{} {
+func (t *TypedTree[T]) clean(values []T) []T {
 	result := values[:0]
 
 	for _, v := range values {
-		if contains(result, v) {
+		if t.contains(result, v) {
 			continue
 		}
 
@@ -360,14 +403,14 @@ func (t *Tree) clean(values []interface{}) []interface{} {
 
 // Count will count all stored values in the tree. It will not filter out
 // duplicate values and thus might return a different result to `len(All())`.
-func (t *Tree) Count() int {
+func (t *TypedTree[T]) Count() int {
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
 
 	return t.count(t.root)
 }
 
-func (t *Tree) count(node *node) int {
+func (t *TypedTree[T]) count(node *node[T]) int {
 	// prepare total
 	total := 0
 
@@ -381,14 +424,14 @@ func (t *Tree) count(node *node) int {
 }
 
 // All will return all stored values in the tree.
-func (t *Tree) All() []interface{} {
+func (t *TypedTree[T]) All() []T {
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
 
-	return t.clean(t.all([]interface{}{}, t.root))
+	return t.clean(t.all([]T{}, t.root))
 }
 
-func (t *Tree) all(result []interface{}, node *node) []interface{} {
+func (t *TypedTree[T]) all(result []T, node *node[T]) []T {
 	// add children to results
 	for _, child := range node.children {
 		result = t.all(result, child)
@@ -398,25 +441,174 @@ func (t *Tree) all(result []interface{}, node *node) []interface{} {
 	return append(result, node.values...)
 }
 
+// Walk will call the supplied function with all topics and their values in
+// lexical order of the segments. Walking stops if the function returns false.
+// The tree must not be modified from the function.
+func (t *TypedTree[T]) Walk(fn func(topic string, values []T) bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	t.walk(nil, t.root, fn)
+}
+
+// WalkPrefix will call the supplied function with the topic that equals the
+// supplied prefix and all topics below it. Walking stops if the function
+// returns false. The tree must not be modified from the function.
+func (t *TypedTree[T]) WalkPrefix(prefix string, fn func(topic string, values []T) bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	// find node
+	segments := strings.Split(prefix, t.Separator)
+	node := t.root
+	for _, segment := range segments {
+		child, ok := node.children[segment]
+		if !ok {
+			return
+		}
+
+		node = child
+	}
+
+	t.walk(segments, node, fn)
+}
+
+func (t *TypedTree[T]) walk(segments []string, node *node[T], fn func(string, []T) bool) bool {
+	// call function if values are present
+	if len(segments) > 0 && len(node.values) > 0 {
+		if !fn(strings.Join(segments, t.Separator), node.values) {
+			return false
+		}
+	}
+
+	// sort keys
+	keys := make([]string, 0, len(node.children))
+	for key := range node.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// walk children
+	for _, key := range keys {
+		if !t.walk(append(segments, key), node.children[key], fn) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type treeEntry[T any] struct {
+	Topic  string `json:"topic"`
+	Values []T    `json:"values"`
+}
+
+// MarshalJSON will encode the topics and values of the tree as JSON. The
+// values must be encodable using the encoding/json package.
+func (t *TypedTree[T]) MarshalJSON() ([]byte, error) {
+	// collect entries
+	entries := make([]treeEntry[T], 0)
+	t.Walk(func(topic string, values []T) bool {
+		entries = append(entries, treeEntry[T]{
+			Topic:  topic,
+			Values: values,
+		})
+
+		return true
+	})
+
+	return json.Marshal(entries)
+}
+
+// UnmarshalJSON will replace the contents of the tree with the topics and
+// values decoded from JSON produced by MarshalJSON.
+func (t *TypedTree[T]) UnmarshalJSON(data []byte) error {
+	// decode entries
+	var entries []treeEntry[T]
+	err := json.Unmarshal(data, &entries)
+	if err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// initialize zero trees
+	if t.equal == nil {
+		tree := NewTypedTree[T](nil)
+		t.Separator = tree.Separator
+		t.WildcardOne = tree.WildcardOne
+		t.WildcardSome = tree.WildcardSome
+		t.equal = tree.equal
+	}
+
+	// rebuild tree, the values are inserted directly as the values of
+	// interface trees may not be comparable
+	t.root = newNode[T]()
+	for _, entry := range entries {
+		// get or create node
+		node := t.root
+		for _, segment := range strings.Split(entry.Topic, t.Separator) {
+			child, ok := node.children[segment]
+			if !ok {
+				child = newNode[T]()
+				node.children[segment] = child
+			}
+
+			node = child
+		}
+
+		// add values
+		node.values = append(node.values, entry.Values...)
+	}
+
+	return nil
+}
+
 // Reset will completely clear the tree.
-func (t *Tree) Reset() {
+func (t *TypedTree[T]) Reset() {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	t.root = newNode()
+	t.root = newNode[T]()
 }
 
 // String will return a string representation of the tree.
-func (t *Tree) String() string {
+func (t *TypedTree[T]) String() string {
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
 
 	return fmt.Sprintf("topic.Tree:%s", t.root.string(0))
 }
 
-func contains(list []interface{}, value interface{}) bool {
+// returns the equality operator for comparable types and a panic free
+// equality function for interfaces and uncomparable types
+func defaultEqual[T any]() func(a, b T) bool {
+	// use equality operator for comparable types
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() != reflect.Interface && typ.Comparable() {
+		return func(a, b T) bool {
+			return interface{}(a) == interface{}(b)
+		}
+	}
+
+	return func(a, b T) bool {
+		// get values
+		va := reflect.ValueOf(interface{}(a))
+		vb := reflect.ValueOf(interface{}(b))
+
+		// use equality operator for comparable values
+		if va.Comparable() && vb.Comparable() {
+			return interface{}(a) == interface{}(b)
+		}
+
+		return reflect.DeepEqual(a, b)
+	}
+}
+
+func (t *TypedTree[T]) contains(list []T, value T) bool {
 	for _, v := range list {
-		if v == value {
+		if t.equal(v, value) {
 			return true
 		}
 	}
@@ -1,6 +1,7 @@
 package topic
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
 
@@ -421,3 +422,185 @@ func BenchmarkTreeSearchWildcardSome(b *testing.B) {
 		tree.Search("#")
 	}
 }
+
+func TestTypedTree(t *testing.T) {
+	type sub struct {
+		Topic string
+		QOS   int
+	}
+
+	tree := NewTypedTree(func(a, b sub) bool {
+		return a.Topic == b.Topic
+	})
+
+	tree.Add("foo/+", sub{Topic: "foo/+", QOS: 1})
+	tree.Add("foo/+", sub{Topic: "foo/+", QOS: 2})
+	tree.Add("foo/#", sub{Topic: "foo/#", QOS: 0})
+
+	assert.Equal(t, 2, tree.Count())
+	assert.Equal(t, []sub{{Topic: "foo/+", QOS: 1}}, tree.Get("foo/+"))
+	assert.Len(t, tree.Match("foo/bar"), 2)
+	assert.Len(t, tree.Search("foo/#"), 2)
+	assert.Len(t, tree.All(), 2)
+
+	tree.Remove("foo/+", sub{Topic: "foo/+"})
+	assert.Equal(t, sub{Topic: "foo/#"}, tree.MatchFirst("foo/bar"))
+
+	tree.Clear(sub{Topic: "foo/#"})
+	assert.Equal(t, sub{}, tree.MatchFirst("foo/bar"))
+	assert.Equal(t, 0, len(tree.root.children))
+}
+
+func TestTypedTreeDefaultEquality(t *testing.T) {
+	tree := NewTypedTree[string](nil)
+
+	tree.Add("foo", "bar")
+	tree.Add("foo", "bar")
+	tree.Add("foo", "baz")
+
+	assert.Equal(t, []string{"bar", "baz"}, tree.Get("foo"))
+}
+
+func TestTreeMatchSystemTopic(t *testing.T) {
+	tree := NewTree()
+
+	tree.Add("#", 1)
+	tree.Add("+/foo", 2)
+	tree.Add("$SYS/#", 3)
+	tree.Add("$SYS/+", 4)
+	tree.Add("$SYS/foo", 5)
+	tree.Add("bar/#", 6)
+
+	assert.ElementsMatch(t, []interface{}{3, 4, 5}, tree.Match("$SYS/foo"))
+	assert.ElementsMatch(t, []interface{}{1, 2, 6}, tree.Match("bar/foo"))
+}
+
+func TestTreeSearchSystemTopic(t *testing.T) {
+	tree := NewTree()
+
+	tree.Add("$SYS/foo", 1)
+	tree.Add("$SYS/bar", 2)
+	tree.Add("foo/bar", 3)
+
+	assert.Equal(t, []interface{}{3}, tree.Search("#"))
+	assert.Equal(t, []interface{}{3}, tree.Search("+/bar"))
+	assert.ElementsMatch(t, []interface{}{1, 2}, tree.Search("$SYS/#"))
+	assert.Equal(t, []interface{}{2}, tree.Search("$SYS/bar"))
+}
+
+func TestTreeWalk(t *testing.T) {
+	tree := NewTree()
+
+	tree.Add("foo/bar", 1)
+	tree.Add("foo/bar", 2)
+	tree.Add("foo", 3)
+	tree.Add("baz/+/#", 4)
+
+	var topics []string
+	var values [][]interface{}
+	tree.Walk(func(topic string, v []interface{}) bool {
+		topics = append(topics, topic)
+		values = append(values, v)
+		return true
+	})
+
+	assert.Equal(t, []string{"baz/+/#", "foo", "foo/bar"}, topics)
+	assert.Equal(t, [][]interface{}{{4}, {3}, {1, 2}}, values)
+
+	topics = nil
+	tree.Walk(func(topic string, v []interface{}) bool {
+		topics = append(topics, topic)
+		return len(topics) < 2
+	})
+
+	assert.Equal(t, []string{"baz/+/#", "foo"}, topics)
+}
+
+func TestTreeWalkPrefix(t *testing.T) {
+	tree := NewTree()
+
+	tree.Add("foo", 1)
+	tree.Add("foo/bar", 2)
+	tree.Add("foo/bar/baz", 3)
+	tree.Add("foobar", 4)
+	tree.Add("bar/foo", 5)
+
+	var topics []string
+	tree.WalkPrefix("foo", func(topic string, v []interface{}) bool {
+		topics = append(topics, topic)
+		return true
+	})
+
+	assert.Equal(t, []string{"foo", "foo/bar", "foo/bar/baz"}, topics)
+
+	topics = nil
+	tree.WalkPrefix("foo/bar", func(topic string, v []interface{}) bool {
+		topics = append(topics, topic)
+		return true
+	})
+
+	assert.Equal(t, []string{"foo/bar", "foo/bar/baz"}, topics)
+
+	topics = nil
+	tree.WalkPrefix("baz", func(topic string, v []interface{}) bool {
+		topics = append(topics, topic)
+		return true
+	})
+
+	assert.Empty(t, topics)
+}
+
+func TestTreeJSON(t *testing.T) {
+	tree := NewTypedTree[string](nil)
+
+	tree.Add("foo/bar", "a")
+	tree.Add("foo/bar", "b")
+	tree.Add("foo/#", "c")
+
+	data, err := json.Marshal(tree)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"topic": "foo/#", "values": ["c"]},
+		{"topic": "foo/bar", "values": ["a", "b"]}
+	]`, string(data))
+
+	tree2 := NewTypedTree[string](nil)
+	tree2.Add("baz", "d")
+
+	err = json.Unmarshal(data, tree2)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, tree2.Count())
+	assert.Empty(t, tree2.Get("baz"))
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, tree2.Match("foo/bar"))
+
+	var tree3 TypedTree[string]
+	err = json.Unmarshal(data, &tree3)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, tree3.Match("foo/bar"))
+
+	data, err = json.Marshal(NewTree())
+	assert.NoError(t, err)
+	assert.Equal(t, "[]", string(data))
+}
+
+func TestTreeJSONUncomparable(t *testing.T) {
+	var tree Tree
+	err := json.Unmarshal([]byte(`[
+		{"topic": "foo", "values": [{"a": 1}, {"b": 2}, [3]]}
+	]`), &tree)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"a": 1.0},
+		map[string]interface{}{"b": 2.0},
+		[]interface{}{3.0},
+	}, tree.Get("foo"))
+	assert.Len(t, tree.Match("foo"), 3)
+	assert.Len(t, tree.Search("foo"), 3)
+	assert.Len(t, tree.All(), 3)
+
+	tree.Add("foo", map[string]interface{}{"a": 1.0})
+	assert.Len(t, tree.Get("foo"), 3)
+
+	tree.Remove("foo", []interface{}{3.0})
+	assert.Len(t, tree.Get("foo"), 2)
+}